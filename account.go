@@ -0,0 +1,241 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// TOTPIssuer is the issuer shown by authenticator apps for enrolled software tokens.
+const TOTPIssuer = "Alta Labs"
+
+// SoftwareToken is a TOTP secret associated with the account, pending verification.
+type SoftwareToken struct {
+	Secret string // Base32 encoded, usable with TOTP and NewTOTPChallengeHandler
+	URI    string // otpauth:// URI for authenticator apps, usually shown as a QR code
+}
+
+// ChangePassword changes the signed in user's password. The password kept to sign in again is updated, unless it
+// was wiped.
+func (auth *AuthClient) ChangePassword(ctx context.Context, previous, proposed string) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.changepassword")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.changepassword", start, err)
+	}()
+
+	accessToken, err := auth.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = auth.cognito.ChangePassword(ctx, &cognitoidentityprovider.ChangePasswordInput{
+		AccessToken:      accessToken,
+		PreviousPassword: aws.String(previous),
+		ProposedPassword: aws.String(proposed),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+
+	// A wiped password stays wiped
+	auth.updateConfig(func(config *Config) {
+		if config.Password != "" {
+			config.Password = proposed
+		}
+	})
+
+	return nil
+}
+
+// ForgotPassword starts a password reset, sending a confirmation code to the user's verified email or phone. The
+// reset is completed with ConfirmForgotPassword. No sign in is needed.
+func (auth *AuthClient) ForgotPassword(ctx context.Context, username string) (delivery *types.CodeDeliveryDetailsType, err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.forgotpassword")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.forgotpassword", start, err)
+	}()
+
+	resp, err := auth.cognito.ForgotPassword(ctx, &cognitoidentityprovider.ForgotPasswordInput{
+		ClientId:   &auth.clientID,
+		Username:   aws.String(username),
+		SecretHash: auth.secretHash(username),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start password reset: %w", err)
+	}
+	return resp.CodeDeliveryDetails, nil
+}
+
+// ConfirmForgotPassword completes a password reset with the code sent by ForgotPassword.
+func (auth *AuthClient) ConfirmForgotPassword(ctx context.Context, username, code, password string) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.confirmforgotpassword")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.confirmforgotpassword", start, err)
+	}()
+
+	_, err = auth.cognito.ConfirmForgotPassword(ctx, &cognitoidentityprovider.ConfirmForgotPasswordInput{
+		ClientId:         &auth.clientID,
+		Username:         aws.String(username),
+		ConfirmationCode: aws.String(code),
+		Password:         aws.String(password),
+		SecretHash:       auth.secretHash(username),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+	return nil
+}
+
+// AssociateSoftwareToken generates a TOTP secret for the signed in user. It isn't used for MFA until a code
+// generated from it is confirmed with VerifySoftwareToken.
+func (auth *AuthClient) AssociateSoftwareToken(ctx context.Context) (token *SoftwareToken, err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.associatesoftwaretoken")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.associatesoftwaretoken", start, err)
+	}()
+
+	accessToken, err := auth.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := auth.cognito.AssociateSoftwareToken(ctx, &cognitoidentityprovider.AssociateSoftwareTokenInput{
+		AccessToken: accessToken,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to associate software token: %w", err)
+	}
+
+	secret := aws.ToString(resp.SecretCode)
+	account := ""
+	if claims, err := auth.Claims(); err == nil {
+		account = claims.Email
+		if account == "" {
+			account = claims.Username
+		}
+	}
+
+	return &SoftwareToken{Secret: secret, URI: totpURI(secret, TOTPIssuer, account)}, nil
+}
+
+// VerifySoftwareToken confirms the secret from AssociateSoftwareToken with a code generated from it, then makes
+// TOTP the user's preferred MFA. Later sign ins are challenged for a code, see NewTOTPChallengeHandler.
+func (auth *AuthClient) VerifySoftwareToken(ctx context.Context, code, deviceName string) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.verifysoftwaretoken")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.verifysoftwaretoken", start, err)
+	}()
+
+	accessToken, err := auth.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	input := &cognitoidentityprovider.VerifySoftwareTokenInput{
+		AccessToken: accessToken,
+		UserCode:    aws.String(code),
+	}
+	if deviceName != "" {
+		input.FriendlyDeviceName = aws.String(deviceName)
+	}
+
+	resp, err := auth.cognito.VerifySoftwareToken(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to verify software token: %w", err)
+	}
+	if resp.Status != types.VerifySoftwareTokenResponseTypeSuccess {
+		return fmt.Errorf("failed to verify software token: status %s", resp.Status)
+	}
+
+	_, err = auth.cognito.SetUserMFAPreference(ctx, &cognitoidentityprovider.SetUserMFAPreferenceInput{
+		AccessToken: accessToken,
+		SoftwareTokenMfaSettings: &types.SoftwareTokenMfaSettingsType{
+			Enabled:      true,
+			PreferredMfa: true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable software token mfa: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns the current access token, renewing it first if it is close to expiring. Sessions resumed
+// from tokens have no access token until they are first refreshed, so they are refreshed early.
+func (auth *AuthClient) accessToken(ctx context.Context) (*string, error) {
+	token, expiry := auth.token()
+	if token == "" {
+		return nil, ErrNotSignedIn
+	}
+
+	auth.mu.RLock()
+	missing := auth.auth != nil && auth.auth.AccessToken == nil && auth.auth.RefreshToken != nil
+	auth.mu.RUnlock()
+
+	if missing || auth.checkExpiry(expiry) != nil {
+		if err := auth.refresh(ctx, token); err != nil {
+			return nil, err
+		}
+	}
+
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+
+	if auth.auth == nil || auth.auth.AccessToken == nil {
+		return nil, errors.New("session has no access token")
+	}
+	return auth.auth.AccessToken, nil
+}
+
+// secretHash returns the SECRET_HASH for the username if the app client has a secret.
+func (auth *AuthClient) secretHash(username string) *string {
+	if auth.clientSecret == nil {
+		return nil
+	}
+	return aws.String(secretHash(username, auth.clientID, *auth.clientSecret))
+}
+
+// totpURI returns the otpauth:// URI of a TOTP secret, following the Key Uri Format used by authenticator apps.
+func totpURI(secret, issuer, account string) string {
+	label := issuer
+	if account != "" {
+		label += ":" + account
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(int(totpPeriod/time.Second)))
+
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: query.Encode()}
+	return uri.String()
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountSelfService(t *testing.T) {
+	const (
+		username = "service@example.com"
+		password = "correct horse"
+		rotated  = "battery staple"
+	)
+	ctx := context.Background()
+
+	newServer := func(t *testing.T) *cognitoServer {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.AddUser(username, password)
+		return cognito
+	}
+	newAuth := func(t *testing.T, cognito *cognitoServer) *AuthClient {
+		auth, err := NewAuthClient("", cognito.authOptions()...)
+		require.NoError(t, err)
+		return auth
+	}
+	signIn := func(t *testing.T, cognito *cognitoServer, password string, handler ChallengeHandler) (*AuthClient, error) {
+		auth := newAuth(t, cognito)
+		return auth, auth.SignInContext(ctx, NewConfig().WithSRPAuth(username, password).WithChallengeHandler(handler))
+	}
+
+	t.Run("ChangePassword should rotate the password", func(t *testing.T) {
+		cognito := newServer(t)
+		auth, err := signIn(t, cognito, password, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, auth.ChangePassword(ctx, password, rotated))
+
+		_, err = signIn(t, cognito, password, nil)
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized, "the old password should be rejected")
+		_, err = signIn(t, cognito, rotated, nil)
+		require.NoError(t, err)
+
+		// Signing in again should use the new password
+		auth.mu.Lock()
+		auth.auth.RefreshToken = nil
+		auth.mu.Unlock()
+		require.NoError(t, auth.RefreshAuthContext(ctx))
+	})
+
+	t.Run("ChangePassword should work for sessions created from tokens", func(t *testing.T) {
+		cognito := newServer(t)
+		auth, err := signIn(t, cognito, password, nil)
+		require.NoError(t, err)
+
+		auth.mu.RLock()
+		refreshToken := *auth.auth.RefreshToken
+		auth.mu.RUnlock()
+
+		client, err := NewAltaClientFromToken(ctx, auth.GetIDToken(), refreshToken,
+			WithAuthOptions(cognito.authOptions()...))
+		require.NoError(t, err)
+		defer client.Close()
+
+		require.NoError(t, client.AuthClient.ChangePassword(ctx, password, rotated))
+		assert.Equal(t, 1, cognito.Refreshes(), "the access token should come from a refresh")
+
+		_, err = signIn(t, cognito, rotated, nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("ChangePassword should reject a wrong previous password", func(t *testing.T) {
+		cognito := newServer(t)
+		auth, err := signIn(t, cognito, password, nil)
+		require.NoError(t, err)
+
+		err = auth.ChangePassword(ctx, "wrong password", rotated)
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized)
+	})
+
+	t.Run("ChangePassword should require a session", func(t *testing.T) {
+		require.ErrorIs(t, newAuth(t, newServer(t)).ChangePassword(ctx, password, rotated), ErrNotSignedIn)
+	})
+
+	t.Run("ForgotPassword should reset the password with the code sent", func(t *testing.T) {
+		cognito := newServer(t)
+		cognito.clientSecret = "client-secret"
+		auth := newAuth(t, cognito)
+
+		delivery, err := auth.ForgotPassword(ctx, username)
+		require.NoError(t, err)
+		assert.Equal(t, types.DeliveryMediumTypeEmail, delivery.DeliveryMedium)
+		assert.Equal(t, "s***@e***", aws.ToString(delivery.Destination))
+
+		err = auth.ConfirmForgotPassword(ctx, username, "000000x", rotated)
+		var mismatch *types.CodeMismatchException
+		require.ErrorAs(t, err, &mismatch)
+
+		require.NoError(t, auth.ConfirmForgotPassword(ctx, username, cognito.ResetCode(username), rotated))
+		_, err = signIn(t, cognito, rotated, nil)
+		require.NoError(t, err)
+	})
+
+	t.Run("Enrolled software tokens should be required at sign in", func(t *testing.T) {
+		cognito := newServer(t)
+		auth, err := signIn(t, cognito, password, nil)
+		require.NoError(t, err)
+
+		token, err := auth.AssociateSoftwareToken(ctx)
+		require.NoError(t, err)
+
+		uri, err := url.Parse(token.URI)
+		require.NoError(t, err)
+		assert.Equal(t, "otpauth", uri.Scheme)
+		assert.Equal(t, "totp", uri.Host)
+		assert.Equal(t, "/Alta Labs:"+username, uri.Path)
+		assert.Equal(t, token.Secret, uri.Query().Get("secret"))
+		assert.Equal(t, "Alta Labs", uri.Query().Get("issuer"))
+		assert.Equal(t, "6", uri.Query().Get("digits"))
+		assert.Equal(t, "30", uri.Query().Get("period"))
+
+		err = auth.VerifySoftwareToken(ctx, "000000x", "ci")
+		var mismatch *types.EnableSoftwareTokenMFAException
+		require.ErrorAs(t, err, &mismatch)
+
+		code, err := TOTP(token.Secret, time.Now())
+		require.NoError(t, err)
+		require.NoError(t, auth.VerifySoftwareToken(ctx, code, "ci"))
+
+		_, err = signIn(t, cognito, password, nil)
+		require.ErrorIs(t, err, ErrUnhandledChallenge, "sign in should now require a code")
+
+		_, err = signIn(t, cognito, password, NewTOTPChallengeHandler(token.Secret))
+		require.NoError(t, err)
+		assert.Equal(t, 2, cognito.MFAChallenges())
+	})
+}
+
+func TestTOTPURI(t *testing.T) {
+	assert.Equal(t, "otpauth://totp/Alta%20Labs:user@example.com?algorithm=SHA1&digits=6&issuer=Alta+Labs&period=30&secret=JBSWY3DPEHPK3PXP",
+		totpURI("JBSWY3DPEHPK3PXP", "Alta Labs", "user@example.com"))
+	assert.Equal(t, "otpauth://totp/Alta%20Labs?algorithm=SHA1&digits=6&issuer=Alta+Labs&period=30&secret=JBSWY3DPEHPK3PXP",
+		totpURI("JBSWY3DPEHPK3PXP", "Alta Labs", ""))
+}
@@ -22,17 +22,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cognitosrp "github.com/alexrudd/cognito-srp/v4"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/mikeee/altalabs-go/util"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -46,6 +50,8 @@ const (
 
 	COGNITO_REGION       = "us-east-1"
 	COGNITO_USER_POOL_ID = "4QbA7N3Uy"
+
+	DefaultExpirySkew = 5 * time.Second // Tokens are renewed this long before their exp claim
 )
 
 const (
@@ -53,8 +59,9 @@ const (
 )
 
 type Config struct {
-	Username string
-	Password string
+	Username         string
+	Password         string
+	ChallengeHandler ChallengeHandler // Answers MFA and password change challenges, see WithChallengeHandler
 }
 
 func NewConfig() *Config {
@@ -73,113 +80,533 @@ type authConfig struct {
 	clientSecret *string
 }
 
+// cognitoClient is the subset of the Cognito identity provider API used by AuthClient.
+type cognitoClient interface {
+	InitiateAuth(ctx context.Context, params *cognitoidentityprovider.InitiateAuthInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.InitiateAuthOutput, error)
+	RespondToAuthChallenge(ctx context.Context, params *cognitoidentityprovider.RespondToAuthChallengeInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error)
+	ConfirmDevice(ctx context.Context, params *cognitoidentityprovider.ConfirmDeviceInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ConfirmDeviceOutput, error)
+	UpdateDeviceStatus(ctx context.Context, params *cognitoidentityprovider.UpdateDeviceStatusInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.UpdateDeviceStatusOutput, error)
+	RevokeToken(ctx context.Context, params *cognitoidentityprovider.RevokeTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.RevokeTokenOutput, error)
+	GlobalSignOut(ctx context.Context, params *cognitoidentityprovider.GlobalSignOutInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.GlobalSignOutOutput, error)
+	ChangePassword(ctx context.Context, params *cognitoidentityprovider.ChangePasswordInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ChangePasswordOutput, error)
+	ForgotPassword(ctx context.Context, params *cognitoidentityprovider.ForgotPasswordInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ForgotPasswordOutput, error)
+	ConfirmForgotPassword(ctx context.Context, params *cognitoidentityprovider.ConfirmForgotPasswordInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.ConfirmForgotPasswordOutput, error)
+	AssociateSoftwareToken(ctx context.Context, params *cognitoidentityprovider.AssociateSoftwareTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.AssociateSoftwareTokenOutput, error)
+	VerifySoftwareToken(ctx context.Context, params *cognitoidentityprovider.VerifySoftwareTokenInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.VerifySoftwareTokenOutput, error)
+	SetUserMFAPreference(ctx context.Context, params *cognitoidentityprovider.SetUserMFAPreferenceInput, optFns ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.SetUserMFAPreferenceOutput, error)
+}
+
+// AuthClient signs in to Cognito and holds the resulting tokens. It is safe for concurrent use.
 type AuthClient struct {
 	*authConfig
+	cognito    cognitoClient
+	telemetry  *telemetry
+	logger     *slog.Logger
+	tokenStore TokenStore
+
+	deviceStore DeviceStore
+	deviceName  string
+
+	mu         sync.RWMutex // Guards userConfig, auth, expiry, device, refresher and signedOut
 	userConfig *Config
-	cognito    *cognitoidentityprovider.Client
 	auth       *types.AuthenticationResultType
-	expiry     int32
+	expiry     time.Time
+	device     *RememberedDevice
+	refresher  *refresher
+	signedOut  bool // Set by sign out so refreshes still in flight don't restore the session
+
+	expirySkew time.Duration // Margin before expiry at which tokens are treated as expired
+
+	refreshGroup singleflight.Group // Shares a single in-flight refresh between callers
+	refreshing   sync.Mutex         // Held for the duration of a refresh, so sign out can wait for it
+}
+
+type newAuthClientOptions func(options *authClientOptions)
+
+// AuthClientOption is an option for NewAuthClient, named so options can be collected and passed on outside this package.
+type AuthClientOption = newAuthClientOptions
+
+type authClientOptions struct {
+	HTTPClient     *http.Client         // Defaults to the AWS SDK's client unless overridden
+	TracerProvider trace.TracerProvider // Defaults to the global provider unless overridden
+	MeterProvider  metric.MeterProvider // Defaults to the global provider unless overridden
+	Logger         *slog.Logger         // Defaults to slog.Default() unless overridden
+	TokenStore     TokenStore           // Defaults to signing in every time
+
+	Endpoint     string  // Cognito base endpoint, defaults to the regional AWS endpoint
+	UserPoolID   string  // Defaults to Alta Labs' user pool
+	ClientID     string  // Defaults to Alta Labs' app client
+	ClientSecret *string // Defaults to none, required by app clients with a secret
+
+	ExpirySkew time.Duration // Defaults to DefaultExpirySkew unless overridden
+
+	DeviceStore DeviceStore // Enables remembering devices, defaults to off
+	DeviceName  string      // Defaults to the hostname
 }
 
-func NewAuthClient(region string) (*AuthClient, error) {
+// WithAuthHTTPClient sets the http.Client used for Cognito requests.
+func WithAuthHTTPClient(client *http.Client) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.HTTPClient = client
+	}
+}
+
+// WithCognitoEndpoint sends Cognito requests to endpoint instead of the AWS endpoint for the region, e.g. a local
+// stand-in for offline tests.
+func WithCognitoEndpoint(endpoint string) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.Endpoint = endpoint
+	}
+}
+
+// WithUserPoolID sets the Cognito user pool, in the form <region>_<id>.
+func WithUserPoolID(userPoolID string) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.UserPoolID = userPoolID
+	}
+}
+
+// WithClientID sets the Cognito app client.
+func WithClientID(clientID string) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.ClientID = clientID
+	}
+}
+
+// WithClientSecret sets the secret of an app client that has one, used to compute SECRET_HASH.
+func WithClientSecret(clientSecret string) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.ClientSecret = &clientSecret
+	}
+}
+
+// WithExpirySkew sets how long before the exp claim tokens are treated as expired, allowing for clock skew between
+// this machine and Cognito and for the time a request takes to arrive.
+func WithExpirySkew(skew time.Duration) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.ExpirySkew = skew
+	}
+}
+
+func loadAuthClientOptions(opts ...newAuthClientOptions) *authClientOptions {
+	options := authClientOptions{
+		UserPoolID: COGNITO_REGION + "_" + COGNITO_USER_POOL_ID,
+		ClientID:   ALTA_CLIENT_ID,
+		ExpirySkew: DefaultExpirySkew,
+	}
+
+	for _, o := range opts {
+		o(&options)
+	}
+	return &options
+}
+
+// NewAuthClient creates a Cognito client for the user pool. An empty region is taken from the user pool ID. AWS
+// credentials and shared config aren't used, the sign in calls don't need them.
+func NewAuthClient(region string, opts ...newAuthClientOptions) (*AuthClient, error) {
+	options := loadAuthClientOptions(opts...)
+
+	poolRegion, _, ok := strings.Cut(options.UserPoolID, "_")
+	if !ok || poolRegion == "" {
+		return nil, fmt.Errorf("invalid user pool id %q: expected <region>_<id>", options.UserPoolID)
+	}
+	if region == "" {
+		region = poolRegion
+	}
+	if options.ClientID == "" {
+		return nil, errors.New("client id is empty")
+	}
+
 	authConfig := authConfig{
-		userPoolID:   COGNITO_REGION + "_" + COGNITO_USER_POOL_ID,
-		clientID:     ALTA_CLIENT_ID,
-		clientSecret: nil,
+		userPoolID:   options.UserPoolID,
+		clientID:     options.ClientID,
+		clientSecret: options.ClientSecret,
+	}
+
+	cognitoOptions := cognitoidentityprovider.Options{
+		Region:      region,
+		Credentials: aws.AnonymousCredentials{},
+	}
+	if options.HTTPClient != nil {
+		cognitoOptions.HTTPClient = options.HTTPClient
 	}
+	if options.Endpoint != "" {
+		cognitoOptions.BaseEndpoint = aws.String(options.Endpoint)
+	}
+
+	authClient := &AuthClient{
+		authConfig:  &authConfig,
+		tokenStore:  options.TokenStore,
+		logger:      options.Logger,
+		cognito:     cognitoidentityprovider.New(cognitoOptions),
+		expirySkew:  options.ExpirySkew,
+		deviceStore: options.DeviceStore,
+		deviceName:  options.DeviceName,
+	}
+	if options.TracerProvider != nil || options.MeterProvider != nil {
+		authClient.telemetry = newTelemetry(options.TracerProvider, options.MeterProvider)
+	}
+
+	return authClient, nil
+}
 
-	awsConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+// NewAuthClientFromToken creates an AuthClient from tokens issued elsewhere, see SignInWithTokens.
+func NewAuthClientFromToken(ctx context.Context, region, idToken, refreshToken string, opts ...newAuthClientOptions) (*AuthClient, error) {
+	authClient, err := NewAuthClient(region, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load aws config: %w", err)
+		return nil, err
+	}
+	if err := authClient.SignInWithTokens(ctx, nil, idToken, refreshToken); err != nil {
+		return nil, err
 	}
+	return authClient, nil
+}
 
-	return &AuthClient{
-		authConfig: &authConfig,
-		cognito:    cognitoidentityprovider.NewFromConfig(awsConfig),
-	}, nil
+// SignInContext authenticates with SRP, answering any MFA or password change challenges with the config's
+// ChallengeHandler.
+func (auth *AuthClient) SignInContext(ctx context.Context, config *Config) error {
+	auth.mu.Lock()
+	auth.signedOut = false
+	auth.mu.Unlock()
+
+	return auth.signIn(ctx, config)
 }
 
+// SignIn calls SignInContext with context.Background().
 func (auth *AuthClient) SignIn(config *Config) error {
+	return auth.SignInContext(context.Background(), config)
+}
+
+// signIn is SignInContext for refreshes, which mustn't undo a sign out made while they were in flight.
+func (auth *AuthClient) signIn(ctx context.Context, config *Config) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.signin")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.signin", start, err)
+	}()
+
 	if config == nil {
 		return errors.New("config is nil")
 	}
-	srp, err := cognitosrp.NewCognitoSRP(config.Username, config.Password, auth.userPoolID, auth.clientID, auth.clientSecret)
+	// Keep a copy so wiping or changing the stored password doesn't modify the caller's config
+	userConfig := *config
+
+	device := auth.loadDevice(ctx, &userConfig)
+	if resumed, err := auth.resumeSession(ctx, &userConfig); err != nil || resumed {
+		return err
+	}
+
+	srp, err := cognitosrp.NewCognitoSRP(userConfig.Username, userConfig.Password, auth.userPoolID, auth.clientID, auth.clientSecret)
 	if err != nil {
 		return fmt.Errorf("failed to create cognito srp: %w", err)
 	}
 
-	resp, err := auth.cognito.InitiateAuth(context.TODO(), &cognitoidentityprovider.InitiateAuthInput{
+	authParams := srp.GetAuthParams()
+	var deviceSRP *srpClient
+	if device != nil {
+		authParams["DEVICE_KEY"] = device.Key
+		if deviceSRP, err = newSRPClient(); err != nil {
+			return err
+		}
+	}
+
+	resp, err := auth.cognito.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
 		AuthFlow:       types.AuthFlowTypeUserSrpAuth,
 		ClientId:       &auth.clientID,
-		AuthParameters: srp.GetAuthParams(),
+		AuthParameters: authParams,
 	})
+	var notFound *types.ResourceNotFoundException
+	if device != nil && errors.As(err, &notFound) {
+		// The device was forgotten, e.g. from the web UI, sign in without it
+		auth.forgetDevice(ctx, &userConfig)
+		device = nil
+		delete(authParams, "DEVICE_KEY")
+
+		resp, err = auth.cognito.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
+			AuthFlow:       types.AuthFlowTypeUserSrpAuth,
+			ClientId:       &auth.clientID,
+			AuthParameters: authParams,
+		})
+	}
 	if err != nil {
 		return fmt.Errorf("failed to initiate auth: %w", err)
 	}
 
-	switch resp.ChallengeName {
-	case types.ChallengeNameTypePasswordVerifier:
-		respPV, err := srp.PasswordVerifierChallenge(resp.ChallengeParameters, time.Now())
-		if err != nil {
-			return fmt.Errorf("failed to verify password: %w", err)
+	if resp.ChallengeName != types.ChallengeNameTypePasswordVerifier {
+		return fmt.Errorf("%w received: %s", ErrUnhandledChallenge, resp.ChallengeName)
+	}
+
+	step := challengeStep{
+		name:       resp.ChallengeName,
+		parameters: resp.ChallengeParameters,
+		session:    resp.Session,
+	}
+	for i := 0; step.result == nil; i++ {
+		if i >= maxChallenges {
+			return fmt.Errorf("too many auth challenges, last received: %s", step.name)
+		}
+
+		var responses map[string]string
+		switch step.name {
+		case types.ChallengeNameTypePasswordVerifier:
+			responses, err = srp.PasswordVerifierChallenge(step.parameters, time.Now())
+			if err != nil {
+				return fmt.Errorf("failed to verify password: %w", err)
+			}
+			if device != nil {
+				responses["DEVICE_KEY"] = device.Key
+			}
+		case types.ChallengeNameTypeDeviceSrpAuth, types.ChallengeNameTypeDevicePasswordVerifier:
+			responses, err = auth.answerDeviceChallenge(&userConfig, device, deviceSRP, step)
+			if err != nil {
+				return err
+			}
+		default:
+			responses, err = auth.answerChallenge(ctx, &userConfig, step)
+			if err != nil {
+				return err
+			}
 		}
 
-		respAuth, err := auth.cognito.RespondToAuthChallenge(context.TODO(), &cognitoidentityprovider.RespondToAuthChallengeInput{
-			ChallengeName:      types.ChallengeNameTypePasswordVerifier,
+		respAuth, err := auth.cognito.RespondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
+			ChallengeName:      step.name,
 			ClientId:           aws.String(srp.GetClientId()),
-			ChallengeResponses: respPV,
+			ChallengeResponses: responses,
+			Session:            step.session,
 		})
 		if err != nil {
-			return fmt.Errorf("failed to respond to auth challenge: %w", err)
+			return fmt.Errorf("failed to respond to auth challenge %s: %w", step.name, err)
 		}
 
-		auth.auth = respAuth.AuthenticationResult
-		auth.userConfig = config
-		auth.expiry = int32(time.Now().Unix()) + auth.auth.ExpiresIn
-		return nil
+		step = challengeStep{
+			name:       respAuth.ChallengeName,
+			parameters: respAuth.ChallengeParameters,
+			session:    respAuth.Session,
+			result:     respAuth.AuthenticationResult,
+		}
+	}
 
-	default:
-		return errors.New("Unhandled auth challenge received: " + string(resp.ChallengeName))
+	auth.setAuth(&userConfig, step.result)
+	auth.confirmDevice(ctx, &userConfig, step.result)
+	auth.storeSession(ctx)
+	return nil
+}
+
+// SignInWithTokens resumes a session from tokens issued by an earlier sign in. A current ID token is used as is,
+// otherwise the refresh token renews it. The config's password, if set, is used to sign in again once the tokens
+// are rejected; without one ErrReauthRequired is returned.
+func (auth *AuthClient) SignInWithTokens(ctx context.Context, config *Config, idToken, refreshToken string) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.signin")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.signin", start, err)
+	}()
+
+	auth.mu.Lock()
+	auth.signedOut = false
+	auth.mu.Unlock()
+
+	if config == nil {
+		config = NewConfig()
 	}
+	userConfig := *config
+
+	var claims *Claims
+	if idToken != "" {
+		if claims, err = ParseClaims(idToken); err != nil {
+			return fmt.Errorf("invalid id token: %w", err)
+		}
+		if userConfig.Username == "" {
+			userConfig.Username = claims.Username
+		}
+	}
+	auth.loadDevice(ctx, &userConfig)
+
+	if claims != nil {
+		if auth.checkExpiry(claims.ExpiresAt) == nil {
+			result := &types.AuthenticationResultType{IdToken: aws.String(idToken)}
+			if refreshToken != "" {
+				result.RefreshToken = aws.String(refreshToken)
+			}
+			auth.setSession(&userConfig, result, claims.ExpiresAt)
+			auth.storeSession(ctx)
+			return nil
+		}
+	}
+
+	if refreshToken != "" {
+		err := auth.refreshWithToken(ctx, &userConfig, idToken, refreshToken)
+		if err == nil {
+			return nil
+		}
+
+		var notAuthorized *types.NotAuthorizedException
+		if !errors.As(err, &notAuthorized) {
+			return fmt.Errorf("failed to refresh auth: %w", err)
+		}
+		if userConfig.Password == "" {
+			return fmt.Errorf("%w: refresh token rejected: %w", ErrReauthRequired, err)
+		}
+	}
+
+	if userConfig.Username == "" || userConfig.Password == "" {
+		return fmt.Errorf("%w: tokens expired and no password", ErrReauthRequired)
+	}
+	return auth.signIn(ctx, &userConfig)
 }
 
-func (auth *AuthClient) RefreshAuth() error {
-	if auth.userConfig == nil {
-		return errors.New("user config is nil")
+// setAuth stores the result of a successful sign in or refresh. The expiry is taken from the ID token's exp claim,
+// falling back to ExpiresIn if the token can't be parsed.
+func (auth *AuthClient) setAuth(config *Config, result *types.AuthenticationResultType) {
+	expiry := time.Unix(time.Now().Unix()+int64(result.ExpiresIn), 0)
+	if claims, err := ParseClaims(aws.ToString(result.IdToken)); err == nil && !claims.ExpiresAt.IsZero() {
+		expiry = claims.ExpiresAt
 	}
-	if err := auth.SignIn(auth.userConfig); err != nil {
-		return fmt.Errorf("failed to refresh auth: %w", err)
+	auth.setSession(config, result, expiry)
+}
+
+func (auth *AuthClient) setSession(config *Config, result *types.AuthenticationResultType, expiry time.Time) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	if auth.signedOut {
+		return
 	}
+	auth.auth = result
+	auth.userConfig = config
+	auth.expiry = expiry
+}
 
-	return nil
+// WipePassword forgets the stored password. Later refreshes rely on the refresh token alone and fail with
+// ErrReauthRequired once it is rejected.
+func (auth *AuthClient) WipePassword() {
+	auth.updateConfig(func(config *Config) {
+		config.Password = ""
+	})
+}
+
+// updateConfig applies update to the config of the session, if there is one.
+func (auth *AuthClient) updateConfig(update func(*Config)) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	// Refreshes read the config after releasing mu, so it's replaced rather than modified
+	if auth.userConfig != nil {
+		config := *auth.userConfig
+		update(&config)
+		auth.userConfig = &config
+	}
+}
+
+// RefreshAuthContext renews the tokens using the refresh token, falling back to signing in with the stored password
+// if the refresh token is rejected. Concurrent calls share a single refresh.
+func (auth *AuthClient) RefreshAuthContext(ctx context.Context) error {
+	return auth.refresh(ctx, auth.GetIDToken())
+}
+
+// RefreshAuth calls RefreshAuthContext with context.Background().
+func (auth *AuthClient) RefreshAuth() error {
+	return auth.RefreshAuthContext(context.Background())
 }
 
 func (auth *AuthClient) GetIDToken() string {
-	if auth.auth != nil {
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+
+	if auth.auth != nil && auth.auth.IdToken != nil {
 		return *auth.auth.IdToken
 	}
 
 	return ""
 }
 
-func (auth *AuthClient) GetExpiry() int32 {
+// token returns the ID token along with its expiry, read together so a refresh can't land in between.
+func (auth *AuthClient) token() (string, time.Time) {
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+
+	if auth.auth == nil || auth.auth.IdToken == nil {
+		return "", time.Time{}
+	}
+	return *auth.auth.IdToken, auth.expiry
+}
+
+// GetExpiryTime returns when the ID token expires, or the zero time if not signed in.
+func (auth *AuthClient) GetExpiryTime() time.Time {
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+
 	if auth.auth != nil {
 		return auth.expiry
 	}
 
-	return 0
+	return time.Time{}
+}
+
+// GetExpiry returns when the ID token expires as a Unix time, or 0 if not signed in.
+func (auth *AuthClient) GetExpiry() int32 {
+	expiry := auth.GetExpiryTime()
+	if expiry.IsZero() {
+		return 0
+	}
+	return int32(expiry.Unix())
 }
 
 type AltaClient struct {
-	Endpoint   string
-	client     *http.Client
-	AuthClient *AuthClient
+	Endpoint      string
+	defaultSite   string
+	client        *http.Client
+	retryPolicy   RetryPolicy
+	rateLimiter   *RateLimiter
+	rateLimitHook RateLimitHook
+	middleware    []Middleware
+	logger        *slog.Logger
+	debug         bool
+	telemetry     *telemetry
+	maxResponse   int64
+	revokeOnClose bool
+	closed        atomic.Bool
+	AuthClient    *AuthClient
 }
 
 type newAltaClientOptions func(options *altaClientOptions)
 
+// AltaClientOption is an option for NewAltaClient, named so options can be collected and passed on outside this package.
+type AltaClientOption = newAltaClientOptions
+
 type altaClientOptions struct {
-	Endpoint string // Defaults to API_BASE_URL unless overridden
+	Endpoint    string            // Defaults to API_BASE_URL unless overridden
+	DefaultSite string            // Site ID used when none is given, e.g. by MqttConn
+	RetryPolicy RetryPolicy       // Defaults to no retries
+	HTTPClient  *http.Client      // Defaults to an empty http.Client unless overridden
+	Transport   http.RoundTripper // Overrides the transport of HTTPClient
+	Timeout     time.Duration     // Overrides the timeout of HTTPClient
+	UserAgent   string            // Defaults to Go's User-Agent unless overridden
+
+	RateLimiter   *RateLimiter  // Defaults to no rate limiting
+	RateLimitHook RateLimitHook // Called when a request is throttled
+
+	Middleware []Middleware // Wraps every REST call, outermost first
+
+	Logger *slog.Logger // Defaults to slog.Default() unless overridden
+	Debug  bool         // Logs every request and response, redacted
+
+	TracerProvider trace.TracerProvider // Defaults to the global provider unless overridden
+	MeterProvider  metric.MeterProvider // Defaults to the global provider unless overridden
+
+	MaxResponseBytes int64 // Defaults to DefaultMaxResponseBytes unless overridden
+
+	TokenStore TokenStore // Persists sessions between processes, defaults to signing in every time
+
+	ChallengeHandler ChallengeHandler // Answers MFA and password change challenges during sign in
+
+	BackgroundRefresh bool // Renews tokens before they expire
+	WipePassword      bool // Forgets the password after signing in
+	RevokeOnClose     bool // Revokes the refresh token on Close
+
+	DeviceStore DeviceStore // Remembers devices to skip MFA, defaults to off
+
+	AuthOptions []newAuthClientOptions // Applied to the AuthClient, e.g. WithCognitoEndpoint
 }
 
 func WithAltaEndpoint(endpoint string) newAltaClientOptions {
@@ -188,6 +615,21 @@ func WithAltaEndpoint(endpoint string) newAltaClientOptions {
 	}
 }
 
+// WithDefaultSite sets the site ID used by calls that aren't given one, such as MqttConn.
+func WithDefaultSite(siteID string) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.DefaultSite = siteID
+	}
+}
+
+// WithAuthOptions applies options to the AuthClient used to sign in, e.g. to use another user pool or a Cognito
+// stand-in.
+func WithAuthOptions(opts ...newAuthClientOptions) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.AuthOptions = append(options.AuthOptions, opts...)
+	}
+}
+
 func loadAltaClientOptions(opts ...newAltaClientOptions) *altaClientOptions {
 	options := altaClientOptions{
 		Endpoint: API_BASE_URL,
@@ -199,147 +641,242 @@ func loadAltaClientOptions(opts ...newAltaClientOptions) *altaClientOptions {
 	return &options
 }
 
+// NewAltaClientContext creates a client signed in with the username and password.
+func NewAltaClientContext(ctx context.Context, username string, password string, opts ...newAltaClientOptions) (*AltaClient, error) {
+	return NewAltaClientWithCredentials(ctx, NewStaticCredentialsProvider(username, password), opts...)
+}
+
+// NewAltaClient calls NewAltaClientContext with context.Background().
 func NewAltaClient(username string, password string, opts ...newAltaClientOptions) (*AltaClient, error) {
+	return NewAltaClientContext(context.Background(), username, password, opts...)
+}
+
+// NewAltaClientWithCredentials creates a client signed in with the credentials resolved by the provider, e.g.
+// DefaultCredentialsChain().
+func NewAltaClientWithCredentials(ctx context.Context, provider CredentialsProvider, opts ...newAltaClientOptions) (*AltaClient, error) {
 	options := loadAltaClientOptions(opts...)
 
-	authClient, err := NewAuthClient(COGNITO_REGION)
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve credentials: %w", err)
+	}
+
+	httpClient := options.httpClient()
+
+	authOptions := append([]newAuthClientOptions{
+		WithAuthHTTPClient(httpClient),
+		WithAuthTracerProvider(options.TracerProvider),
+		WithAuthMeterProvider(options.MeterProvider),
+		WithAuthLogger(options.Logger),
+		WithAuthTokenStore(options.TokenStore),
+		WithAuthDeviceStore(options.DeviceStore),
+	}, options.AuthOptions...)
+
+	authClient, err := NewAuthClient("", authOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create auth client: %w", err)
 	}
 
-	clientConfig := NewConfig().WithSRPAuth(username, password)
+	clientConfig := NewConfig().WithSRPAuth(creds.Username, creds.Password).WithChallengeHandler(options.ChallengeHandler)
 
-	if err := authClient.SignIn(clientConfig); err != nil {
-		return nil, fmt.Errorf("failed to sign in: %w", err)
+	if creds.HasTokens() {
+		err = authClient.SignInWithTokens(ctx, clientConfig, creds.IDToken, creds.RefreshToken)
+	} else {
+		err = authClient.SignInContext(ctx, clientConfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign in with %s credentials: %w", creds.Source, err)
+	}
+
+	return newAltaClient(options, httpClient, authClient), nil
+}
+
+// NewAltaClientFromToken creates a client from tokens issued elsewhere, without ever handling the password. The ID
+// token is used until the expiry in its exp claim, then renewed with the refresh token. Requests fail with
+// ErrReauthRequired once the tokens can no longer be renewed, e.g. if no refresh token was given.
+func NewAltaClientFromToken(ctx context.Context, idToken, refreshToken string, opts ...newAltaClientOptions) (*AltaClient, error) {
+	provider := StaticCredentialsProvider{Credentials: Credentials{IDToken: idToken, RefreshToken: refreshToken}}
+	return NewAltaClientWithCredentials(ctx, provider, opts...)
+}
+
+// newAltaClient creates a client around a signed in AuthClient.
+func newAltaClient(options *altaClientOptions, httpClient *http.Client, authClient *AuthClient) *AltaClient {
+	if options.WipePassword {
+		authClient.WipePassword()
+	}
+	if options.BackgroundRefresh {
+		authClient.StartBackgroundRefresh()
+	}
+
+	var tel *telemetry
+	if options.TracerProvider != nil || options.MeterProvider != nil {
+		tel = newTelemetry(options.TracerProvider, options.MeterProvider)
 	}
 
 	return &AltaClient{
-		Endpoint:   options.Endpoint,
-		client:     &http.Client{},
-		AuthClient: authClient,
-	}, nil
+		Endpoint:      options.Endpoint,
+		defaultSite:   options.DefaultSite,
+		client:        httpClient,
+		retryPolicy:   options.RetryPolicy,
+		rateLimiter:   options.RateLimiter,
+		rateLimitHook: options.RateLimitHook,
+		middleware:    options.Middleware,
+		logger:        options.Logger,
+		debug:         options.Debug,
+		telemetry:     tel,
+		maxResponse:   options.MaxResponseBytes,
+		revokeOnClose: options.RevokeOnClose,
+		AuthClient:    authClient,
+	}
 }
 
 var ErrorAuthExpired = errors.New("auth token expired")
 
-func (a *AltaClient) checkToken() error {
-	// Check and renew tokens expired or within 5 seconds
-	// TODO: This is a bad idea, refactor this
-	if a.AuthClient.GetExpiry() <= int32(time.Now().Unix())+5 {
+// DefaultSite returns the site ID set with WithDefaultSite, or an empty string.
+func (a *AltaClient) DefaultSite() string {
+	return a.defaultSite
+}
+
+// checkExpiry returns ErrorAuthExpired if the expiry has passed or is within the skew margin.
+func (auth *AuthClient) checkExpiry(expiry time.Time) error {
+	if !time.Now().Add(auth.expirySkew).Before(expiry) {
 		return ErrorAuthExpired
 	}
 	return nil
 }
 
-func (a *AltaClient) request(method, url string, body []byte) (*http.Request, error) {
-	if err := a.checkToken(); err != nil {
-		slog.Info("Refreshing auth token", slog.String("error", err.Error()))
-		if err := a.AuthClient.RefreshAuth(); err != nil {
-			slog.Error("Failed to refresh auth token", slog.String("error", err.Error()))
-		}
-		slog.Info("Refreshed auth token")
+// request builds the http.Request for a REST call.
+func (a *AltaClient) request(ctx context.Context, req *Request) (*http.Request, error) {
+	url := a.Endpoint + req.Path
+	if req.RawQuery != "" {
+		url += "?" + req.RawQuery
 	}
 
-	var reqBodyStream io.Reader
-	var reqBody []byte
-
-	if body != nil {
-		// Append token to the payload/body
-		// TODO: This is a bad idea, refactor this
-		tokenPair, err := util.GenerateTokenPair(a.AuthClient.GetIDToken())
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate token pair: %w", err)
-		}
-
-		reqBody, err = util.AppendTokenToJSONBody(body, tokenPair)
-		if err != nil {
-			return nil, fmt.Errorf("failed to append token to json body: %w", err)
-		}
-
-		reqBodyStream = bytes.NewReader(reqBody)
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
 	}
 
-	req, err := http.NewRequest(method, a.Endpoint+url, reqBodyStream)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	for key, values := range req.Header {
+		httpReq.Header[key] = values
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
 
-	return req, nil
+	return httpReq, nil
 }
 
-func (a *AltaClient) getRequest(path string, params, dest interface{}) error {
-	if params != nil {
-		path += "?" + util.StructToParams(params)
+func (a *AltaClient) getRequest(ctx context.Context, operation, path string, params, dest interface{}) error {
+	req := &Request{
+		Operation: operation,
+		Method:    http.MethodGet,
+		Path:      path,
+		Payload:   params,
+		Header:    http.Header{},
 	}
-
-	req, err := a.request(http.MethodGet, path, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if params != nil {
+		query, err := util.EncodeQuery(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode query: %w", err)
+		}
+		req.RawQuery = query.Encode()
 	}
 
-	req.Header.Set("Token", a.AuthClient.GetIDToken())
+	return a.do(ctx, req, dest)
+}
 
-	resp, err := a.client.Do(req)
+func (a *AltaClient) postRequest(ctx context.Context, operation, path string, payload interface{}, dest interface{}) error {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed with status code: %d", resp.StatusCode)
-	}
+	return a.do(ctx, &Request{
+		Operation: operation,
+		Method:    http.MethodPost,
+		Path:      path,
+		Payload:   payload,
+		Header:    http.Header{},
+		Body:      body,
+	}, dest)
+}
 
-	if dest == nil {
-		return nil
+// do sends the request, retrying transient failures as allowed by the retry policy.
+func (a *AltaClient) do(ctx context.Context, req *Request, dest interface{}) (err error) {
+	if a.closed.Load() {
+		return ErrClientClosed
 	}
 
+	attempts := a.retryPolicy.attempts(req.Method)
+
+	ctx, span := a.tel().startOperation(ctx, req)
+	attempt := 1
 	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Fatalf("failed to close response body: %v", err)
-		}
+		a.tel().endOperation(span, attempt, err)
 	}()
 
-	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
-	}
+	for ; ; attempt++ {
+		err = a.sendWithReauth(ctx, req, dest)
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
 
-	return nil
-}
+		delay, ok := a.retryPolicy.backoff(attempt, err)
+		if !ok {
+			return err
+		}
 
-func (a *AltaClient) postRequest(path string, payload interface{}, dest interface{}) error {
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		a.tel().recordRetry(ctx, req)
+		a.log().DebugContext(ctx, "Retrying request", slog.String("path", req.Path), slog.Int("attempt", attempt+1),
+			slog.Duration("delay", delay), slog.String("error", err.Error()))
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return errors.Join(err, sleepErr)
+		}
 	}
+}
 
-	req, err := a.request(http.MethodPost, path, body)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// sendWithReauth sends the request and, if the API rejects the token as Unauthorized, refreshes the credentials
+// once and replays the request with a freshly built body. The token can be rejected before its local expiry, e.g.
+// if it has been revoked or the local clock is skewed.
+func (a *AltaClient) sendWithReauth(ctx context.Context, req *Request, dest interface{}) error {
+	// The token middleware may refresh before sending, so the token to replace is the one it reports sending
+	var sent string
+	err := a.send(context.WithValue(ctx, sentTokenKey{}, &sent), req, dest)
+	if !errors.Is(err, ErrUnauthorized) {
+		return err
 	}
 
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+	// Requests rejected together share a single refresh, later ones replay with the token it produced
+	a.log().InfoContext(ctx, "Auth token rejected, refreshing", slog.String("error", err.Error()))
+	if refreshErr := a.AuthClient.refresh(ctx, sent); refreshErr != nil {
+		return errors.Join(err, refreshErr)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	return a.send(ctx, req, dest)
+}
+
+// send passes a copy of the request through the middleware chain, leaving the original intact for replays.
+func (a *AltaClient) send(ctx context.Context, req *Request, dest interface{}) error {
+	if err := a.waitForRateLimit(ctx, req.Path); err != nil {
+		return fmt.Errorf("rate limit wait failed: %w", err)
 	}
 
-	if dest == nil {
+	start := time.Now()
+	resp, err := a.handler()(ctx, req.clone())
+	if err == nil && resp == nil {
+		err = errors.New("middleware returned no response")
+	}
+	if err != nil {
+		a.tel().recordRequest(ctx, req, 0, time.Since(start))
 		return err
 	}
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Fatalf("failed to close response body: %v", err)
-		}
-	}()
+	err = a.handleResponse(req, resp, dest)
+	a.tel().recordRequest(ctx, req, resp.StatusCode, time.Since(start))
 
-	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	return nil
+	return err
 }
@@ -17,13 +17,14 @@ package altalabs
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"encoding/json"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -42,7 +43,7 @@ func TestConfig(t *testing.T) {
 }
 
 func TestAuthClient(t *testing.T) {
-	expiry := int32(time.Now().Unix()) + 10
+	expiry := time.Now().Add(10 * time.Second)
 	testAuth := &AuthClient{
 		authConfig: nil,
 		userConfig: &Config{},
@@ -59,7 +60,12 @@ func TestAuthClient(t *testing.T) {
 	}
 
 	t.Run("Test expiry retrieval", func(t *testing.T) {
-		assert.Equal(t, expiry, testAuth.GetExpiry())
+		assert.Equal(t, expiry, testAuth.GetExpiryTime())
+		assert.Equal(t, int32(expiry.Unix()), testAuth.GetExpiry())
+	})
+
+	t.Run("Expiry should be zero when not signed in", func(t *testing.T) {
+		assert.Equal(t, int32(0), (&AuthClient{}).GetExpiry())
 	})
 }
 
@@ -86,9 +92,6 @@ func TestAltaClient(t *testing.T) {
 		testString := "abc123"
 		testIDToken := "id_token"
 
-		cognitoConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"))
-		require.NoError(t, err)
-
 		testClient.AuthClient = &AuthClient{
 			authConfig: &authConfig{
 				userPoolID:   "abc_123",
@@ -99,7 +102,7 @@ func TestAltaClient(t *testing.T) {
 				Username: "",
 				Password: "",
 			},
-			cognito: cognitoidentityprovider.NewFromConfig(cognitoConfig),
+			cognito: cognitoidentityprovider.New(cognitoidentityprovider.Options{Region: "us-east-1"}),
 			auth: &types.AuthenticationResultType{
 				AccessToken: &testString,
 				ExpiresIn:   int32(time.Now().Unix()) + 10, // Insert a valid expiry
@@ -115,7 +118,7 @@ func TestAltaClient(t *testing.T) {
 
 		t.Run("GET-style request", func(t *testing.T) {
 
-			req, err := testClient.request("GET", "https://manage.alta.inc/api/", nil)
+			req, err := testClient.request(context.Background(), &Request{Method: "GET", Path: "https://manage.alta.inc/api/"})
 			require.NoError(t, err)
 			assert.Equal(t, testRequest.Method, req.Method)
 			assert.Equal(t, testRequest.URL.Host, req.URL.Host)
@@ -126,7 +129,13 @@ func TestAltaClient(t *testing.T) {
 			serialisedBodyBytes := []byte(`{"key":"value"}`)
 			postBodyBytes := []byte(`{"key":"value","token":"` + testIDToken + `"}`)
 
-			req, err := testClient.request("POST", "https://manage.alta.inc/api/", serialisedBodyBytes)
+			var req *http.Request
+			handler := testClient.tokenMiddleware(func(ctx context.Context, r *Request) (*http.Response, error) {
+				var err error
+				req, err = testClient.request(ctx, r)
+				return nil, err
+			})
+			_, err := handler(context.Background(), &Request{Method: "POST", Path: "https://manage.alta.inc/api/", Body: serialisedBodyBytes})
 			require.NoError(t, err)
 			assert.Equal(t, "POST", req.Method)
 			assert.Equal(t, testRequest.URL.Host, req.URL.Host)
@@ -138,48 +147,295 @@ func TestAltaClient(t *testing.T) {
 			assert.Equal(t, postBodyBytes, body, "POST-style request body is not as expected")
 		})
 	})
+}
 
-	t.Run("Test checkToken", func(t *testing.T) {
-		t.Run("unexpired", func(t *testing.T) {
-			testClient.AuthClient = &AuthClient{
-				authConfig: &authConfig{
-					userPoolID:   "",
-					clientID:     "",
-					clientSecret: nil,
-				},
-				userConfig: &Config{},
-				cognito:    nil,
-				auth: &types.AuthenticationResultType{
-					AccessToken:       nil,
-					ExpiresIn:         int32(time.Now().Unix()) + 10, // Insert a valid expiry
-					IdToken:           nil,
-					NewDeviceMetadata: nil,
-					RefreshToken:      nil,
-					TokenType:         nil,
-				},
-				expiry: int32(time.Now().Unix()) + 10,
+func TestAltaClientContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	testClient := newTestClient(server, nil)
+
+	t.Run("Cancelled context should abort the request", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := testClient.ListSitesContext(ctx)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Live context should complete the request", func(t *testing.T) {
+		sites, err := testClient.ListSitesContext(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, sites)
+	})
+
+	t.Run("Methods without a context should complete the request", func(t *testing.T) {
+		sites, err := testClient.ListSites()
+		require.NoError(t, err)
+		assert.Empty(t, sites)
+	})
+}
+
+func TestAltaClientReauth(t *testing.T) {
+	staleToken := "stale_token"
+	freshToken := "fresh_token"
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		token := r.Header.Get("Token")
+		if r.Method == http.MethodPost {
+			var body struct {
+				Token string `json:"token"`
 			}
-			err := testClient.checkToken()
-			require.NoError(t, err)
-		})
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			token = body.Token
+		}
 
-		t.Run("expired", func(t *testing.T) {
-
-			testClient.AuthClient = &AuthClient{
-				authConfig: nil,
-				userConfig: &Config{},
-				cognito:    nil,
-				auth: &types.AuthenticationResultType{
-					AccessToken:       nil,
-					ExpiresIn:         int32(time.Now().Unix()) - 10, // Insert a valid expiry
-					IdToken:           nil,
-					NewDeviceMetadata: nil,
-					RefreshToken:      nil,
-					TokenType:         nil,
-				},
+		if token != freshToken {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`"Unauthorized"`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"group_id"}`))
+	}))
+	defer server.Close()
+
+	t.Run("GET should be replayed after re-authenticating", func(t *testing.T) {
+		requests = 0
+		cognito := newFakeCognito(freshToken)
+		testClient := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now().Add(time.Minute)))
+
+		_, err := testClient.GetSiteContext(context.Background(), "abc123")
+		require.NoError(t, err)
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, 1, cognito.SignIns())
+		assert.Equal(t, freshToken, testClient.AuthClient.GetIDToken())
+	})
+
+	t.Run("POST should be replayed with the new body token", func(t *testing.T) {
+		requests = 0
+		cognito := newFakeCognito(freshToken)
+		testClient := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now().Add(time.Minute)))
+
+		id, err := testClient.AddGroupContext(context.Background(), "group")
+		require.NoError(t, err)
+		assert.Equal(t, "group_id", *id)
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+
+	t.Run("A token refreshed just before sending should be refreshed again if rejected", func(t *testing.T) {
+		cognito := newFakeCognito("refreshed_token")
+
+		var sent []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get("Token")
+			sent = append(sent, token)
+			if token != freshToken {
+				// Revoked as soon as it was issued, the next sign in gets a token the API accepts
+				cognito.mu.Lock()
+				cognito.idToken = freshToken
+				cognito.mu.Unlock()
+
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`"Unauthorized"`))
+				return
 			}
-			err := testClient.checkToken()
-			require.Error(t, err)
-		})
+			_, _ = w.Write([]byte(`{"id":"abc123"}`))
+		}))
+		defer server.Close()
+
+		testClient := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now()))
+
+		_, err := testClient.GetSiteContext(context.Background(), "abc123")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"refreshed_token", freshToken}, sent)
+		assert.Equal(t, 1, cognito.Refreshes(), "the rejected token should have been renewed")
+	})
+
+	t.Run("Only a single retry should be attempted", func(t *testing.T) {
+		requests = 0
+		cognito := newFakeCognito("still_rejected")
+		testClient := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now().Add(time.Minute)))
+
+		_, err := testClient.GetSiteContext(context.Background(), "abc123")
+		require.ErrorIs(t, err, ErrUnauthorized)
+		assert.Equal(t, 2, requests)
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+}
+
+func TestNewAltaClientFromToken(t *testing.T) {
+	idToken := newTestJWT(map[string]any{"cognito:username": "user", "exp": time.Now().Add(time.Hour).Unix()})
+	expiredToken := newTestJWT(map[string]any{"cognito:username": "user", "exp": time.Now().Add(-time.Hour).Unix()})
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, idToken, r.Header.Get("Token"))
+		_, _ = w.Write([]byte(`[{"id":"site","name":"home"}]`))
+	}))
+	defer server.Close()
+
+	t.Run("A current ID token should be used without signing in", func(t *testing.T) {
+		client, err := NewAltaClientFromToken(context.Background(), idToken, "", WithAltaEndpoint(server.URL+"/"))
+		require.NoError(t, err)
+		defer client.Close()
+
+		sites, err := client.ListSitesContext(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, sites, 1)
+
+		claims, err := ParseClaims(idToken)
+		require.NoError(t, err)
+		assert.Equal(t, claims.ExpiresAt, client.AuthClient.GetExpiryTime(), "expiry should come from the exp claim")
+	})
+
+	t.Run("An expired ID token without a refresh token should require re-authentication", func(t *testing.T) {
+		_, err := NewAltaClientFromToken(context.Background(), expiredToken, "", WithAltaEndpoint(server.URL+"/"))
+		require.ErrorIs(t, err, ErrReauthRequired)
+	})
+
+	t.Run("Requests should fail once the token expires and can't be renewed", func(t *testing.T) {
+		requests = 0
+		auth := &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			cognito:    newFakeCognito("id_token"),
+		}
+		require.NoError(t, auth.SignInWithTokens(context.Background(), nil, idToken, ""))
+		auth.expiry = time.Now().Add(-time.Second)
+
+		client := &AltaClient{Endpoint: server.URL + "/", client: server.Client(), AuthClient: auth}
+		_, err := client.ListSitesContext(context.Background())
+		require.ErrorIs(t, err, ErrReauthRequired)
+		assert.Equal(t, 0, requests, "an expired token should not be sent")
+	})
+
+	t.Run("An expiring token should be renewed with the refresh token", func(t *testing.T) {
+		requests = 0
+		cognito := newFakeCognito(idToken)
+		auth := &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			cognito:    cognito,
+		}
+		require.NoError(t, auth.SignInWithTokens(context.Background(), nil, expiredToken, "refresh_token"))
+		auth.expiry = time.Now().Add(-time.Second)
+
+		client := &AltaClient{Endpoint: server.URL + "/", client: server.Client(), AuthClient: auth}
+		_, err := client.ListSitesContext(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, cognito.Refreshes())
+		assert.Equal(t, 0, cognito.SignIns())
+		assert.Equal(t, 1, requests)
+	})
+}
+
+func TestNewAltaClientCognitoEndpoint(t *testing.T) {
+	newAPI := func(t *testing.T, cognito *cognitoServer) *httptest.Server {
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !cognito.ValidIDToken(r.Header.Get("Token")) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`"Unauthorized"`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id":"site","name":"home"}]`))
+		}))
+		t.Cleanup(api.Close)
+		return api
+	}
+
+	t.Run("Sign in should complete against the Cognito stand-in", func(t *testing.T) {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.AddUser("user@example.com", "correct horse")
+		api := newAPI(t, cognito)
+
+		client, err := NewAltaClientContext(context.Background(), "user@example.com", "correct horse",
+			WithAltaEndpoint(api.URL+"/"), WithAuthOptions(cognito.authOptions()...))
+		require.NoError(t, err)
+		defer client.Close()
+
+		assert.Equal(t, 1, cognito.SignIns())
+		sites, err := client.ListSitesContext(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, sites, 1)
+	})
+
+	t.Run("A wrong password should be rejected", func(t *testing.T) {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.AddUser("user@example.com", "correct horse")
+
+		_, err := NewAltaClientContext(context.Background(), "user@example.com", "battery staple",
+			WithAuthOptions(cognito.authOptions()...))
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized)
+		assert.Equal(t, 0, cognito.SignIns())
+	})
+
+	t.Run("App clients with a secret should receive the secret hash", func(t *testing.T) {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.clientSecret = "client-secret"
+		cognito.AddUser("user@example.com", "correct horse")
+
+		client, err := NewAltaClientContext(context.Background(), "user@example.com", "correct horse",
+			WithAuthOptions(cognito.authOptions()...))
+		require.NoError(t, err)
+
+		require.NoError(t, client.AuthClient.RefreshAuthContext(context.Background()))
+		assert.Equal(t, 1, cognito.Refreshes())
+		assert.Equal(t, 1, cognito.SignIns())
+
+		_, err = NewAltaClientContext(context.Background(), "user@example.com", "correct horse",
+			WithAuthOptions(cognito.authOptions()[:3]...))
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized, "a missing secret hash should be rejected")
+	})
+
+	t.Run("An unknown client ID should be rejected", func(t *testing.T) {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.AddUser("user@example.com", "correct horse")
+
+		_, err := NewAltaClientContext(context.Background(), "user@example.com", "correct horse",
+			WithAuthOptions(append(cognito.authOptions(), WithClientID("other-client"))...))
+		var notFound *types.ResourceNotFoundException
+		require.ErrorAs(t, err, &notFound)
+	})
+}
+
+func TestNewAuthClientOptions(t *testing.T) {
+	t.Run("Defaults should target the Alta Labs user pool", func(t *testing.T) {
+		auth, err := NewAuthClient("")
+		require.NoError(t, err)
+		assert.Equal(t, COGNITO_REGION+"_"+COGNITO_USER_POOL_ID, auth.userPoolID)
+		assert.Equal(t, ALTA_CLIENT_ID, auth.clientID)
+		assert.Nil(t, auth.clientSecret)
+	})
+
+	t.Run("The region should come from the user pool ID", func(t *testing.T) {
+		auth, err := NewAuthClient("", WithUserPoolID("ap-southeast-2_abc"),
+			WithClientID("client"), WithClientSecret("secret"))
+		require.NoError(t, err)
+		assert.Equal(t, "ap-southeast-2", auth.cognito.(*cognitoidentityprovider.Client).Options().Region)
+		assert.Equal(t, "client", auth.clientID)
+		require.NotNil(t, auth.clientSecret)
+		assert.Equal(t, "secret", *auth.clientSecret)
+	})
+
+	t.Run("Malformed user pool IDs should error", func(t *testing.T) {
+		_, err := NewAuthClient("", WithUserPoolID("abc"))
+		require.Error(t, err)
+	})
+
+	t.Run("An empty client ID should error", func(t *testing.T) {
+		_, err := NewAuthClient("", WithClientID(""))
+		require.Error(t, err)
 	})
 }
@@ -0,0 +1,497 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/mikeee/altalabs-go"
+)
+
+// Group is a group held by the server.
+type Group struct {
+	ID     string
+	Name   string
+	Emails []string
+}
+
+type siteRecord struct {
+	Name   string
+	Icon   *string
+	Emails []string
+	Site   altalabs.Site
+}
+
+// listedSite is an entry of the sites/list response.
+type listedSite struct {
+	ID      string              `json:"id"`
+	Name    string              `json:"name"`
+	Icon    *string             `json:"icon"`
+	Devices []any               `json:"devices"`
+	Online  int                 `json:"online"`
+	Emails  []string            `json:"emails"`
+	Perms   map[string]sitePerm `json:"perms"`
+}
+
+type sitePerm struct {
+	Admin             bool `json:"admin"`
+	AllPasswords      bool `json:"allPasswords"`
+	UnlockedPasswords bool `json:"unlockedPasswords"`
+}
+
+// AddSite adds a site owned by DefaultEmail, returning its ID.
+func (s *Server) AddSite(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addSite(name, "", "", DefaultEmail)
+}
+
+func (s *Server) addSite(name, icon, tz, email string) string {
+	record := &siteRecord{Name: name, Emails: []string{email}}
+	if icon != "" {
+		record.Icon = &icon
+	}
+	record.Site.ID = randomID()
+	record.Site.Tz = tz
+
+	s.sites = append(s.sites, record)
+	return record.Site.ID
+}
+
+// Site returns the settings of a site, and its name.
+func (s *Server) Site(id string) (site altalabs.Site, name string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.findSite(id)
+	if record == nil {
+		return altalabs.Site{}, "", false
+	}
+	return clone(record.Site), record.Name, true
+}
+
+func (s *Server) findSite(id string) *siteRecord {
+	for _, record := range s.sites {
+		if record.Site.ID == id {
+			return record
+		}
+	}
+	return nil
+}
+
+// AddSSID adds an SSID, returning its ID. The ID is generated if empty.
+func (s *Server) AddSSID(ssid altalabs.SSID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ssid = clone(ssid)
+	if ssid.ID == "" {
+		ssid.ID = randomID()
+	}
+	s.ssids = append(s.ssids, &ssid)
+	return ssid.ID
+}
+
+// SSID returns an SSID.
+func (s *Server) SSID(id string) (altalabs.SSID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ssid := s.findSSID(id)
+	if ssid == nil {
+		return altalabs.SSID{}, false
+	}
+	return clone(*ssid), true
+}
+
+func (s *Server) findSSID(id string) *altalabs.SSID {
+	for _, ssid := range s.ssids {
+		if ssid.ID == id {
+			return ssid
+		}
+	}
+	return nil
+}
+
+// AddGroup adds a group with no members, returning its ID.
+func (s *Server) AddGroup(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newGroup(name)
+}
+
+func (s *Server) newGroup(name string) string {
+	group := &Group{ID: randomID(), Name: name, Emails: []string{}}
+	s.groups = append(s.groups, group)
+	return group.ID
+}
+
+// Group returns a group.
+func (s *Server) Group(id string) (Group, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group := s.findGroup(id)
+	if group == nil {
+		return Group{}, false
+	}
+	return clone(*group), true
+}
+
+func (s *Server) findGroup(id string) *Group {
+	for _, group := range s.groups {
+		if group.ID == id {
+			return group
+		}
+	}
+	return nil
+}
+
+// AddDevice adds a client device to the site in its Siteid, returning its ID. The ID is generated if empty.
+func (s *Server) AddDevice(device altalabs.Device) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device = clone(device)
+	if device.ID == "" {
+		device.ID = randomID()
+	}
+	s.devices = append(s.devices, &device)
+	return device.ID
+}
+
+// Device returns a client device.
+func (s *Server) Device(id string) (altalabs.Device, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device := s.findDevice(id)
+	if device == nil {
+		return altalabs.Device{}, false
+	}
+	return clone(*device), true
+}
+
+func (s *Server) findDevice(id string) *altalabs.Device {
+	for _, device := range s.devices {
+		if device.ID == id {
+			return device
+		}
+	}
+	return nil
+}
+
+func (s *Server) listSites(_ *http.Request, _ string, _ []byte) (int, any) {
+	sites := make([]listedSite, 0, len(s.sites))
+	for _, record := range s.sites {
+		perms := map[string]sitePerm{}
+		for _, email := range record.Emails {
+			perms[email] = sitePerm{Admin: true, AllPasswords: true, UnlockedPasswords: true}
+		}
+
+		sites = append(sites, listedSite{
+			ID:      record.Site.ID,
+			Name:    record.Name,
+			Icon:    record.Icon,
+			Devices: []any{},
+			Emails:  record.Emails,
+			Perms:   perms,
+		})
+	}
+	return http.StatusOK, sites
+}
+
+func (s *Server) getSite(r *http.Request, _ string, _ []byte) (int, any) {
+	record := s.findSite(r.URL.Query().Get("id"))
+	if record == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+	return http.StatusOK, record.Site
+}
+
+func (s *Server) createSite(_ *http.Request, email string, body []byte) (int, any) {
+	var req struct {
+		Icon string `json:"icon"`
+		Name string `json:"name"`
+		Tz   string `json:"tz"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Name == "" {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	id := s.addSite(req.Name, req.Icon, req.Tz, email)
+	return http.StatusOK, map[string]string{"id": id, "name": req.Name}
+}
+
+func (s *Server) renameSite(_ *http.Request, _ string, body []byte) (int, any) {
+	var req struct {
+		SiteID string `json:"siteid"`
+		Name   string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Name == "" {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	record := s.findSite(req.SiteID)
+	if record == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+	record.Name = req.Name
+	return http.StatusOK, nil
+}
+
+func (s *Server) updateSite(_ *http.Request, _ string, body []byte) (int, any) {
+	var site altalabs.Site
+	if err := json.Unmarshal(body, &site); err != nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	record := s.findSite(site.ID)
+	if record == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+	record.Site = site
+	return http.StatusOK, nil
+}
+
+func (s *Server) listSSIDs(_ *http.Request, _ string, _ []byte) (int, any) {
+	list := altalabs.SSIDList{SSIDs: make([]altalabs.SSID, 0, len(s.ssids))}
+	for _, ssid := range s.ssids {
+		list.SSIDs = append(list.SSIDs, *ssid)
+	}
+	return http.StatusOK, list
+}
+
+func (s *Server) getSSID(r *http.Request, _ string, _ []byte) (int, any) {
+	ssid := s.findSSID(r.URL.Query().Get("id"))
+	if ssid == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+	return http.StatusOK, ssid
+}
+
+// putSSID adds an SSID if the config has no ID, and otherwise edits the SSID with that ID. Edits only change the
+// fields present in the config.
+func (s *Server) putSSID(_ *http.Request, email string, body []byte) (int, any) {
+	var req struct {
+		Config map[string]json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Config == nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	var id string
+	if raw, ok := req.Config["id"]; ok {
+		if err := json.Unmarshal(raw, &id); err != nil {
+			return errorResponse(http.StatusBadRequest)
+		}
+	}
+	delete(req.Config, "id")
+
+	ssid := &altalabs.SSID{Emails: []string{email}}
+	if id != "" {
+		if ssid = s.findSSID(id); ssid == nil {
+			return errorResponse(http.StatusNotFound)
+		}
+	}
+
+	updated := clone(*ssid)
+	if err := applySSIDConfig(&updated, req.Config); err != nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+	if updated.Ssid == "" {
+		return errorResponse(http.StatusBadRequest)
+	}
+	for _, siteID := range updated.Sites {
+		if s.findSite(siteID) == nil {
+			return errorResponse(http.StatusBadRequest)
+		}
+	}
+
+	if id != "" {
+		*ssid = updated
+		return http.StatusOK, nil
+	}
+
+	updated.ID = randomID()
+	s.ssids = append(s.ssids, &updated)
+	return http.StatusOK, altalabs.NewSSIDResponse{ID: updated.ID}
+}
+
+// applySSIDConfig overlays the fields of a request's config onto an SSID. The name and sites are kept outside the
+// config in responses.
+func applySSIDConfig(ssid *altalabs.SSID, fields map[string]json.RawMessage) error {
+	if raw, ok := fields["ssid"]; ok {
+		if err := json.Unmarshal(raw, &ssid.Ssid); err != nil {
+			return err
+		}
+		delete(fields, "ssid")
+	}
+	if raw, ok := fields["sites"]; ok {
+		if err := json.Unmarshal(raw, &ssid.Sites); err != nil {
+			return err
+		}
+		delete(fields, "sites")
+	}
+
+	return overlay(&ssid.Config, fields)
+}
+
+func (s *Server) deleteSSID(_ *http.Request, _ string, body []byte) (int, any) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	i := slices.IndexFunc(s.ssids, func(ssid *altalabs.SSID) bool { return ssid.ID == req.ID })
+	if i < 0 {
+		return errorResponse(http.StatusNotFound)
+	}
+	s.ssids = slices.Delete(s.ssids, i, i+1)
+	return http.StatusOK, nil
+}
+
+func (s *Server) addGroup(_ *http.Request, _ string, body []byte) (int, any) {
+	var req altalabs.NewGroupRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Name == "" {
+		return errorResponse(http.StatusBadRequest)
+	}
+	return http.StatusOK, altalabs.NewGroupResponse{ID: s.newGroup(req.Name)}
+}
+
+func (s *Server) editGroup(_ *http.Request, _ string, body []byte) (int, any) {
+	var req altalabs.EditGroupRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Name == "" {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	group := s.findGroup(req.ID)
+	if group == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+	group.Name = req.Name
+	group.Emails = append([]string{}, req.Emails...)
+	return http.StatusOK, nil
+}
+
+func (s *Server) deleteGroup(_ *http.Request, _ string, body []byte) (int, any) {
+	var req altalabs.DeleteGroupRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	i := slices.IndexFunc(s.groups, func(group *Group) bool { return group.ID == req.ID })
+	if i < 0 {
+		return errorResponse(http.StatusNotFound)
+	}
+	s.groups = slices.Delete(s.groups, i, i+1)
+	return http.StatusOK, nil
+}
+
+// listDevices lists the client devices of a site, given by ID or name.
+func (s *Server) listDevices(r *http.Request, _ string, _ []byte) (int, any) {
+	name := r.URL.Query().Get("siteName")
+
+	var record *siteRecord
+	for _, candidate := range s.sites {
+		if candidate.Site.ID == name || candidate.Name == name {
+			record = candidate
+			break
+		}
+	}
+	if record == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+
+	devices := make(altalabs.Devices, 0)
+	for _, device := range s.devices {
+		if device.Siteid == record.Site.ID {
+			devices = append(devices, *device)
+		}
+	}
+	return http.StatusOK, devices
+}
+
+// editDevice changes the fields of a client device present in the request.
+func (s *Server) editDevice(_ *http.Request, _ string, body []byte) (int, any) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+
+	var id string
+	if err := json.Unmarshal(fields["id"], &id); err != nil || id == "" {
+		return errorResponse(http.StatusBadRequest)
+	}
+	delete(fields, "id")
+
+	device := s.findDevice(id)
+	if device == nil {
+		return errorResponse(http.StatusNotFound)
+	}
+
+	updated := clone(*device)
+	if err := overlay(&updated, fields); err != nil {
+		return errorResponse(http.StatusBadRequest)
+	}
+	*device = updated
+	return http.StatusOK, nil
+}
+
+// errorResponse returns the status text as the response, the form the API uses for errors.
+func errorResponse(status int) (int, any) {
+	return status, http.StatusText(status)
+}
+
+// overlay sets the fields of dest present in fields, keyed by their JSON names.
+func overlay(dest any, fields map[string]json.RawMessage) error {
+	current, err := json.Marshal(dest)
+	if err != nil {
+		return err
+	}
+
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(current, &merged); err != nil {
+		return err
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, dest)
+}
+
+// clone returns a deep copy of v, so state isn't shared with callers.
+func clone[T any](v T) T {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	var copied T
+	if err := json.Unmarshal(body, &copied); err != nil {
+		panic(err)
+	}
+	return copied
+}
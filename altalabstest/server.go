@@ -0,0 +1,359 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package altalabstest provides an in-process fake of the Alta Labs API for testing code built on
+// altalabs.AltaClient, without touching the cloud:
+//
+//	server := altalabstest.NewServer(t)
+//	siteID := server.AddSite("office")
+//
+//	client, err := server.NewClient(ctx)
+//	...
+//	sites, err := client.ListSitesContext(ctx)
+//
+// State is kept in memory and shared by every token the server issues. Requests are authenticated like the real
+// API: GET requests must carry a valid ID token in the Token header, and POST requests as a "token" field in the
+// JSON body. Failures can be injected per endpoint with InjectFailure.
+package altalabstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikeee/altalabs-go"
+)
+
+const (
+	DefaultEmail    = "user@example.com" // Email of the tokens issued by NewClient
+	DefaultTokenTTL = time.Hour          // Lifetime of the tokens issued by NewToken
+
+	apiPrefix = "/api/"
+)
+
+// Request is a request received by the server.
+type Request struct {
+	Method string
+	Path   string // Relative to the API, e.g. "sites/list"
+	Email  string // Email of the token used, empty if the token was rejected
+	Body   []byte // JSON body with the token removed, nil for GET requests
+}
+
+// Failure makes matching requests fail instead of being served.
+type Failure struct {
+	Method     string      // Matches any method if empty
+	Path       string      // Relative to the API, e.g. "sites/list", matches any path if empty
+	StatusCode int         // Defaults to http.StatusInternalServerError
+	Body       string      // Defaults to the status text as a JSON string, as the API does
+	Header     http.Header // Added to the response, e.g. Retry-After
+	Times      int         // Number of requests to fail, every request if zero
+	Drop       bool        // Closes the connection without responding, instead of sending StatusCode
+}
+
+func (f *Failure) matches(method, path string) bool {
+	return (f.Method == "" || f.Method == method) && (f.Path == "" || f.Path == path)
+}
+
+// Server is a fake Alta Labs API. The zero value isn't usable, create one with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	now      func() time.Time
+	tokens   map[string]string // ID token to email
+	sites    []*siteRecord
+	ssids    []*altalabs.SSID
+	groups   []*Group
+	devices  []*altalabs.Device
+	failures []*Failure
+	requests []Request
+}
+
+// NewServer starts a fake API, closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		now:    time.Now,
+		tokens: map[string]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Endpoint returns the base URL of the API, for altalabs.WithAltaEndpoint.
+func (s *Server) Endpoint() string {
+	return s.URL + apiPrefix
+}
+
+// NewToken issues an ID token for the email, valid for DefaultTokenTTL. The token is an unsigned JWT carrying the
+// claims altalabs.ParseClaims reads.
+func (s *Server) NewToken(email string) string {
+	return s.newToken(email, DefaultTokenTTL)
+}
+
+// NewExpiredToken issues an ID token for the email that expired a minute ago, which the server rejects.
+func (s *Server) NewExpiredToken(email string) string {
+	return s.newToken(email, -time.Minute)
+}
+
+func (s *Server) newToken(email string, ttl time.Duration) string {
+	now := s.now()
+	claims, _ := json.Marshal(map[string]any{
+		"sub":              "sub-" + email,
+		"email":            email,
+		"cognito:username": email,
+		"token_use":        "id",
+		"iat":              now.Unix(),
+		"exp":              now.Add(ttl).Unix(),
+		"jti":              randomID(),
+	})
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	token := header + "." + base64.RawURLEncoding.EncodeToString(claims) + "."
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = email
+	return token
+}
+
+// RevokeToken makes the server reject a token it issued.
+func (s *Server) RevokeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+}
+
+// NewClient returns a client signed in as DefaultEmail with a token from NewToken. Options given here are applied
+// after the server's endpoint and HTTP client.
+func (s *Server) NewClient(ctx context.Context, opts ...altalabs.AltaClientOption) (*altalabs.AltaClient, error) {
+	opts = append([]altalabs.AltaClientOption{
+		altalabs.WithAltaEndpoint(s.Endpoint()),
+		altalabs.WithHTTPClient(s.Client()),
+	}, opts...)
+	return altalabs.NewAltaClientFromToken(ctx, s.NewToken(DefaultEmail), "", opts...)
+}
+
+// InjectFailure makes requests matching the failure fail, until it has been used Times times or ClearFailures is
+// called. The earliest injected failure matching a request is used.
+func (s *Server) InjectFailure(failure Failure) {
+	if failure.StatusCode == 0 {
+		failure.StatusCode = http.StatusInternalServerError
+	}
+	if failure.Body == "" {
+		body, _ := json.Marshal(http.StatusText(failure.StatusCode))
+		failure.Body = string(body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure)
+}
+
+// ClearFailures removes every injected failure.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// Requests returns the requests received so far, including failed ones.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// takeFailure returns the first failure matching the request, consuming one of its uses.
+func (s *Server) takeFailure(method, path string) *Failure {
+	for i, failure := range s.failures {
+		if !failure.matches(method, path) {
+			continue
+		}
+		if failure.Times > 0 {
+			failure.Times--
+			if failure.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return failure
+	}
+	return nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, apiPrefix)
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	var body []byte
+	if r.Method == http.MethodPost {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, body, err := requestToken(r, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email, authorized := s.authorize(token)
+	s.requests = append(s.requests, Request{Method: r.Method, Path: path, Email: email, Body: body})
+
+	if failure := s.takeFailure(r.Method, path); failure != nil {
+		writeFailure(w, failure)
+		return
+	}
+	if !authorized {
+		// The API reports missing, expired and invalid tokens alike as a http-400
+		writeJSON(w, http.StatusBadRequest, altalabs.API_Unauthorized)
+		return
+	}
+
+	s.route(w, r, path, email, body)
+}
+
+// requestToken returns the token sent with the request, and the body without it.
+func requestToken(r *http.Request, body []byte) (string, []byte, error) {
+	if r.Method != http.MethodPost {
+		return r.Header.Get("Token"), nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", nil, err
+	}
+
+	var token string
+	if raw, ok := fields["token"]; ok {
+		if err := json.Unmarshal(raw, &token); err != nil {
+			return "", nil, err
+		}
+		delete(fields, "token")
+	}
+
+	body, err := json.Marshal(fields)
+	return token, body, err
+}
+
+// authorize returns the email of a token issued by the server, if it hasn't expired.
+func (s *Server) authorize(token string) (string, bool) {
+	email, ok := s.tokens[token]
+	if !ok {
+		return "", false
+	}
+
+	claims, err := altalabs.ParseClaims(token)
+	if err != nil || !s.now().Before(claims.ExpiresAt) {
+		return "", false
+	}
+	return email, true
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, path, email string, body []byte) {
+	type handler func(r *http.Request, email string, body []byte) (int, any)
+
+	routes := map[string]handler{
+		http.MethodGet + " sites/list":        s.listSites,
+		http.MethodGet + " site":              s.getSite,
+		http.MethodPost + " sites/new":        s.createSite,
+		http.MethodPost + " sites/rename":     s.renameSite,
+		http.MethodPost + " sites/update":     s.updateSite,
+		http.MethodGet + " wifi/ssid/list":    s.listSSIDs,
+		http.MethodGet + " wifi/ssid":         s.getSSID,
+		http.MethodPost + " wifi/ssid":        s.putSSID,
+		http.MethodPost + " wifi/ssid/delete": s.deleteSSID,
+		http.MethodPost + " group/add":        s.addGroup,
+		http.MethodPost + " group/edit":       s.editGroup,
+		http.MethodPost + " group/delete":     s.deleteGroup,
+		http.MethodGet + " device/list":       s.listDevices,
+		http.MethodPost + " client/edit":      s.editDevice,
+	}
+
+	route, ok := routes[r.Method+" "+path]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	status, response := route(r, email, body)
+	writeJSON(w, status, response)
+}
+
+func writeFailure(w http.ResponseWriter, failure *Failure) {
+	if failure.Drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+
+	for key, values := range failure.Header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(failure.StatusCode)
+	_, _ = io.WriteString(w, failure.Body)
+}
+
+// writeError responds with the status text as a JSON string, the form the API uses for errors.
+func writeError(w http.ResponseWriter, status int) {
+	writeJSON(w, status, http.StatusText(status))
+}
+
+func writeJSON(w http.ResponseWriter, status int, response any) {
+	var body bytes.Buffer
+	if response != nil {
+		if err := json.NewEncoder(&body).Encode(response); err != nil {
+			status = http.StatusInternalServerError
+			body.Reset()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
+
+const idAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomID returns a 16 character ID in the style of the API's, e.g. "E6WIX3G8vYq4g7d5".
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = idAlphabet[int(b[i])%len(idAlphabet)]
+	}
+	return string(b)
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabstest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikeee/altalabs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T, server *Server, opts ...altalabs.AltaClientOption) *altalabs.AltaClient {
+	client, err := server.NewClient(context.Background(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestSites(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer(t)
+	client := newClient(t, server)
+
+	officeID := server.AddSite("office")
+
+	created, err := client.CreateSiteContext(ctx, "home", altalabs.WithSiteTz("Europe/London"), altalabs.WithSiteIcon("house"))
+	require.NoError(t, err)
+	assert.Equal(t, "home", created.Name)
+	assert.Len(t, created.ID, 16)
+
+	sites, err := client.ListSitesContext(ctx)
+	require.NoError(t, err)
+	require.Len(t, sites, 2)
+	assert.Equal(t, officeID, sites[0].ID)
+	assert.Nil(t, sites[0].Icon)
+	assert.Equal(t, "house", *sites[1].Icon)
+	assert.Equal(t, []string{DefaultEmail}, sites[1].Emails)
+	assert.True(t, sites[1].Perms[DefaultEmail].Admin)
+
+	require.NoError(t, client.RenameSiteContext(ctx, "office", "headquarters"))
+	_, name, ok := server.Site(officeID)
+	require.True(t, ok)
+	assert.Equal(t, "headquarters", name)
+
+	site, err := client.GetSiteContext(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/London", site.Tz)
+
+	site.SyslogHost = "syslog.example.com"
+	require.NoError(t, client.UpdateSiteContext(ctx, *site))
+	stored, _, _ := server.Site(created.ID)
+	assert.Equal(t, "syslog.example.com", stored.SyslogHost)
+
+	_, err = client.GetSiteContext(ctx, "missing")
+	require.ErrorIs(t, err, altalabs.ErrNotFound)
+	require.ErrorIs(t, client.RenameSiteByIDContext(ctx, "missing", "name"), altalabs.ErrNotFound)
+	_, err = client.CreateSiteContext(ctx, "")
+	require.ErrorIs(t, err, altalabs.ErrBadRequest)
+}
+
+func TestSSIDs(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer(t)
+	client := newClient(t, server)
+	siteID := server.AddSite("office")
+
+	var add altalabs.NewSSIDRequest
+	add.Config.Ssid = "office-wifi"
+	add.Config.Security = "wpa2"
+	add.Config.Bands = "both"
+	add.Config.Sites = []string{siteID}
+	id, err := client.AddSSIDContext(ctx, add)
+	require.NoError(t, err)
+
+	list, err := client.ListSSIDContext(ctx)
+	require.NoError(t, err)
+	require.Len(t, list.SSIDs, 1)
+	assert.Equal(t, *id, list.SSIDs[0].ID)
+	assert.Equal(t, "office-wifi", list.SSIDs[0].Ssid)
+	assert.Equal(t, []string{siteID}, list.SSIDs[0].Sites)
+
+	var edit altalabs.EditSSIDRequest
+	edit.Config.ID = *id
+	edit.Config.Ssid = "office-guest"
+	require.NoError(t, client.EditSSIDContext(ctx, edit))
+
+	got, err := client.GetSSIDContext(ctx, *id)
+	require.NoError(t, err)
+	assert.Equal(t, "office-guest", got.Ssid)
+	assert.Equal(t, "wpa2", got.Config.Security, "fields missing from the edit should be kept")
+
+	stored, ok := server.SSID(*id)
+	require.True(t, ok)
+	assert.Equal(t, "both", stored.Config.Bands)
+
+	add.Config.Sites = []string{"missing"}
+	_, err = client.AddSSIDContext(ctx, add)
+	require.ErrorIs(t, err, altalabs.ErrBadRequest)
+
+	require.NoError(t, client.DeleteSSIDContext(ctx, *id))
+	_, err = client.GetSSIDContext(ctx, *id)
+	require.ErrorIs(t, err, altalabs.ErrNotFound)
+	require.ErrorIs(t, client.DeleteSSIDContext(ctx, *id), altalabs.ErrNotFound)
+}
+
+func TestGroups(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer(t)
+	client := newClient(t, server)
+
+	id, err := client.AddGroupContext(ctx, "admins")
+	require.NoError(t, err)
+
+	group, ok := server.Group(*id)
+	require.True(t, ok)
+	assert.Equal(t, Group{ID: *id, Name: "admins", Emails: []string{}}, group)
+
+	require.NoError(t, client.EditGroupContext(ctx, altalabs.EditGroupRequest{ID: *id, Name: "operators", Emails: []string{"ops@example.com"}}))
+	group, _ = server.Group(*id)
+	assert.Equal(t, Group{ID: *id, Name: "operators", Emails: []string{"ops@example.com"}}, group)
+
+	require.NoError(t, client.DeleteGroupContext(ctx, *id))
+	_, ok = server.Group(*id)
+	assert.False(t, ok)
+	require.ErrorIs(t, client.DeleteGroupContext(ctx, *id), altalabs.ErrNotFound)
+}
+
+func TestDevices(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer(t)
+	client := newClient(t, server)
+
+	officeID := server.AddSite("office")
+	homeID := server.AddSite("home")
+	laptopID := server.AddDevice(altalabs.Device{Siteid: officeID, Icon: "laptop", Vlan: 10})
+	server.AddDevice(altalabs.Device{Siteid: homeID, Icon: "phone"})
+
+	devices, err := client.ListDevicesContext(ctx, officeID)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, laptopID, devices[0].ID)
+
+	devices, err = client.ListDevicesContext(ctx, "home")
+	require.NoError(t, err, "sites should also be found by name")
+	require.Len(t, devices, 1)
+	assert.Equal(t, "phone", devices[0].Icon)
+
+	require.NoError(t, client.EditDeviceContext(ctx, altalabs.Device{ID: laptopID, Icon: "desktop"}))
+	device, ok := server.Device(laptopID)
+	require.True(t, ok)
+	assert.Equal(t, "desktop", device.Icon)
+	assert.Equal(t, 10, device.Vlan, "fields missing from the edit should be kept")
+
+	require.ErrorIs(t, client.EditDeviceContext(ctx, altalabs.Device{ID: "missing"}), altalabs.ErrNotFound)
+	_, err = client.ListDevicesContext(ctx, "missing")
+	require.ErrorIs(t, err, altalabs.ErrNotFound)
+}
+
+func TestTokens(t *testing.T) {
+	server := NewServer(t)
+
+	do := func(t *testing.T, method, path, token, body string) (int, string) {
+		req, err := http.NewRequest(method, server.Endpoint()+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Token", token)
+		}
+
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, strings.TrimSpace(string(respBody))
+	}
+
+	token := server.NewToken("other@example.com")
+
+	t.Run("GET requests should need the Token header", func(t *testing.T) {
+		status, _ := do(t, http.MethodGet, "sites/list", token, "")
+		assert.Equal(t, http.StatusOK, status)
+
+		status, body := do(t, http.MethodGet, "sites/list", "", "")
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, `"Unauthorized"`, body)
+	})
+
+	t.Run("POST requests should need the token in the body", func(t *testing.T) {
+		status, _ := do(t, http.MethodPost, "group/add", token, `{"name":"header only"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = do(t, http.MethodPost, "group/add", "", `{"name":"body","token":"`+token+`"}`)
+		assert.Equal(t, http.StatusOK, status)
+
+		requests := server.Requests()
+		last := requests[len(requests)-1]
+		assert.Equal(t, "other@example.com", last.Email)
+		assert.JSONEq(t, `{"name":"body"}`, string(last.Body), "the token should be removed")
+	})
+
+	t.Run("Expired and unknown tokens should be rejected", func(t *testing.T) {
+		status, _ := do(t, http.MethodGet, "sites/list", server.NewExpiredToken("other@example.com"), "")
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = do(t, http.MethodGet, "sites/list", "not-a-token", "")
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("Revoked tokens should be rejected", func(t *testing.T) {
+		client := newClient(t, server)
+		server.RevokeToken(client.AuthClient.GetIDToken())
+
+		_, err := client.ListSitesContext(context.Background())
+		require.ErrorIs(t, err, altalabs.ErrUnauthorized)
+	})
+
+	t.Run("Unknown endpoints should not be found", func(t *testing.T) {
+		status, _ := do(t, http.MethodGet, "sites/missing", token, "")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
+
+func TestInjectFailure(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Failures should be used Times times", func(t *testing.T) {
+		server := NewServer(t)
+		client := newClient(t, server)
+
+		server.InjectFailure(Failure{Path: "sites/list", StatusCode: http.StatusServiceUnavailable, Times: 1})
+
+		_, err := client.ListSitesContext(ctx)
+		require.ErrorIs(t, err, altalabs.ErrServerError)
+		var apiErr *altalabs.APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, "Service Unavailable", apiErr.Message)
+
+		_, err = client.ListSitesContext(ctx)
+		require.NoError(t, err)
+	})
+
+	t.Run("Transient failures should be retried", func(t *testing.T) {
+		server := NewServer(t)
+		client := newClient(t, server, altalabs.WithRetryPolicy(altalabs.RetryPolicy{MaxAttempts: 3}))
+
+		server.InjectFailure(Failure{
+			Method:     http.MethodGet,
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"0"}},
+			Times:      2,
+		})
+
+		_, err := client.ListSitesContext(ctx)
+		require.NoError(t, err)
+		assert.Len(t, server.Requests(), 3)
+	})
+
+	t.Run("Failures should persist until cleared", func(t *testing.T) {
+		server := NewServer(t)
+		client := newClient(t, server)
+
+		server.InjectFailure(Failure{Path: "group/add", StatusCode: http.StatusForbidden, Body: `{"message":"read only"}`})
+		for range 2 {
+			_, err := client.AddGroupContext(ctx, "admins")
+			require.ErrorIs(t, err, altalabs.ErrForbidden)
+			require.ErrorContains(t, err, "read only")
+		}
+
+		_, err := client.ListSitesContext(ctx)
+		require.NoError(t, err, "other endpoints should be unaffected")
+
+		server.ClearFailures()
+		_, err = client.AddGroupContext(ctx, "admins")
+		require.NoError(t, err)
+	})
+
+	t.Run("Dropped connections should fail the request", func(t *testing.T) {
+		server := NewServer(t)
+		client := newClient(t, server, altalabs.WithTimeout(5*time.Second))
+
+		server.InjectFailure(Failure{Path: "sites/list", Drop: true, Times: 1})
+		_, err := client.ListSitesContext(ctx)
+		require.Error(t, err)
+
+		var apiErr *altalabs.APIError
+		assert.NotErrorAs(t, err, &apiErr)
+	})
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// maxChallenges bounds the number of challenges answered in a single sign in.
+const maxChallenges = 8
+
+// ErrUnhandledChallenge is returned when Cognito issues a challenge that can't be answered, e.g. an MFA challenge
+// without a ChallengeHandler.
+var ErrUnhandledChallenge = errors.New("unhandled auth challenge")
+
+// Challenge is a step Cognito requires before issuing tokens.
+type Challenge struct {
+	Name       types.ChallengeNameType
+	Parameters map[string]string // e.g. CODE_DELIVERY_DESTINATION for SMS_MFA
+}
+
+// ChallengeHandler answers the challenges Cognito issues after the password has been verified.
+type ChallengeHandler interface {
+	// MFACode returns the code for a SOFTWARE_TOKEN_MFA or SMS_MFA challenge.
+	MFACode(ctx context.Context, challenge Challenge) (string, error)
+
+	// SelectMFAType picks one of the MFA types enabled for the account, answering SELECT_MFA_TYPE.
+	SelectMFAType(ctx context.Context, options []types.ChallengeNameType) (types.ChallengeNameType, error)
+
+	// NewPassword returns the password to set when an account must change its password, answering
+	// NEW_PASSWORD_REQUIRED. The attributes are sent as user attributes, and must include any listed in the
+	// challenge's requiredAttributes parameter.
+	NewPassword(ctx context.Context, challenge Challenge) (password string, attributes map[string]string, err error)
+}
+
+// WithChallengeHandler sets the handler for MFA and password change challenges raised during sign in.
+func (c *Config) WithChallengeHandler(handler ChallengeHandler) *Config {
+	c.ChallengeHandler = handler
+	return c
+}
+
+// WithChallengeHandler sets the handler for MFA and password change challenges raised during sign in.
+func WithChallengeHandler(handler ChallengeHandler) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.ChallengeHandler = handler
+	}
+}
+
+// challengeStep is the state of a sign in between calls to Cognito.
+type challengeStep struct {
+	name       types.ChallengeNameType
+	parameters map[string]string
+	session    *string
+	result     *types.AuthenticationResultType
+}
+
+// answerChallenge builds the responses to a challenge following PASSWORD_VERIFIER. A NEW_PASSWORD_REQUIRED answer
+// updates the password in config.
+func (auth *AuthClient) answerChallenge(ctx context.Context, config *Config, step challengeStep) (map[string]string, error) {
+	handler := config.ChallengeHandler
+	challenge := Challenge{Name: step.name, Parameters: step.parameters}
+
+	username := step.parameters["USER_ID_FOR_SRP"]
+	if username == "" {
+		username = config.Username
+	}
+	responses := map[string]string{"USERNAME": username}
+	if auth.clientSecret != nil {
+		responses["SECRET_HASH"] = secretHash(username, auth.clientID, *auth.clientSecret)
+	}
+
+	switch {
+	case handler == nil:
+		return nil, fmt.Errorf("%w received: %s, no challenge handler set", ErrUnhandledChallenge, step.name)
+
+	case step.name == types.ChallengeNameTypeSoftwareTokenMfa || step.name == types.ChallengeNameTypeSmsMfa:
+		code, err := handler.MFACode(ctx, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mfa code: %w", err)
+		}
+		responses[string(step.name)+"_CODE"] = code
+
+	case step.name == types.ChallengeNameTypeSelectMfaType:
+		var options []types.ChallengeNameType
+		if err := json.Unmarshal([]byte(step.parameters["MFAS_CAN_CHOOSE"]), &options); err != nil {
+			return nil, fmt.Errorf("failed to decode mfa types: %w", err)
+		}
+
+		choice, err := handler.SelectMFAType(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select mfa type: %w", err)
+		}
+		responses["ANSWER"] = string(choice)
+
+	case step.name == types.ChallengeNameTypeNewPasswordRequired:
+		password, attributes, err := handler.NewPassword(ctx, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get new password: %w", err)
+		}
+		if password == "" {
+			return nil, errors.New("new password is empty")
+		}
+
+		responses["NEW_PASSWORD"] = password
+		for name, value := range attributes {
+			responses["userAttributes."+strings.TrimPrefix(name, "userAttributes.")] = value
+		}
+		config.Password = password
+
+	default:
+		return nil, fmt.Errorf("%w received: %s", ErrUnhandledChallenge, step.name)
+	}
+
+	return responses, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testChallengeHandler answers SMS codes with a fixed code, prefers SMS MFA and sets a fixed new password.
+type testChallengeHandler struct {
+	smsCode    string
+	challenges []Challenge
+	options    []types.ChallengeNameType
+}
+
+func (h *testChallengeHandler) MFACode(_ context.Context, challenge Challenge) (string, error) {
+	h.challenges = append(h.challenges, challenge)
+	return h.smsCode, nil
+}
+
+func (h *testChallengeHandler) SelectMFAType(_ context.Context, options []types.ChallengeNameType) (types.ChallengeNameType, error) {
+	h.options = options
+	return types.ChallengeNameTypeSmsMfa, nil
+}
+
+func (h *testChallengeHandler) NewPassword(_ context.Context, challenge Challenge) (string, map[string]string, error) {
+	h.challenges = append(h.challenges, challenge)
+	return "new_password", map[string]string{"userAttributes.name": "Service Account"}, nil
+}
+
+func TestSignInChallenges(t *testing.T) {
+	totpSecret := "JBSWY3DPEHPK3PXP"
+
+	newTestAuthClient := func(cognito cognitoClient) *AuthClient {
+		return &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			cognito:    cognito,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		challenges []types.ChallengeNameType
+		handler    ChallengeHandler
+	}{
+		{"TOTP MFA", []types.ChallengeNameType{types.ChallengeNameTypeSoftwareTokenMfa},
+			NewTOTPChallengeHandler(totpSecret)},
+		{"SMS MFA", []types.ChallengeNameType{types.ChallengeNameTypeSmsMfa},
+			&testChallengeHandler{smsCode: "123456"}},
+		{"MFA type selection", []types.ChallengeNameType{types.ChallengeNameTypeSelectMfaType, types.ChallengeNameTypeSmsMfa},
+			&testChallengeHandler{smsCode: "123456"}},
+		{"new password then MFA", []types.ChallengeNameType{types.ChallengeNameTypeNewPasswordRequired, types.ChallengeNameTypeSoftwareTokenMfa},
+			&combinedHandler{TOTPChallengeHandler: NewTOTPChallengeHandler(totpSecret), passwords: &testChallengeHandler{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cognito := newFakeCognito("id_token")
+			cognito.challenges = tt.challenges
+			cognito.totpSecret = totpSecret
+			cognito.smsCode = "123456"
+
+			auth := newTestAuthClient(cognito)
+			config := NewConfig().WithSRPAuth("username", "password").WithChallengeHandler(tt.handler)
+			require.NoError(t, auth.SignInContext(context.Background(), config))
+
+			assert.Equal(t, "id_token", auth.GetIDToken())
+			assert.Equal(t, 1, cognito.SignIns())
+			require.Len(t, cognito.answers, len(tt.challenges)+1)
+			for i, answer := range cognito.answers[1:] {
+				assert.Equal(t, tt.challenges[i], answer.ChallengeName)
+				assert.Equal(t, "session-"+string(tt.challenges[i]), aws.ToString(answer.Session))
+				assert.Equal(t, "username", answer.ChallengeResponses["USERNAME"])
+			}
+		})
+	}
+
+	t.Run("Challenge parameters should be passed to the handler", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		cognito.challenges = []types.ChallengeNameType{types.ChallengeNameTypeSelectMfaType, types.ChallengeNameTypeSmsMfa}
+		cognito.smsCode = "123456"
+		handler := &testChallengeHandler{smsCode: "123456"}
+
+		auth := newTestAuthClient(cognito)
+		require.NoError(t, auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password").WithChallengeHandler(handler)))
+
+		assert.Equal(t, []types.ChallengeNameType{types.ChallengeNameTypeSmsMfa, types.ChallengeNameTypeSoftwareTokenMfa}, handler.options)
+		require.Len(t, handler.challenges, 1)
+		assert.Equal(t, "+*******1234", handler.challenges[0].Parameters["CODE_DELIVERY_DESTINATION"])
+	})
+
+	t.Run("A new password should replace the stored password", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		cognito.challenges = []types.ChallengeNameType{types.ChallengeNameTypeNewPasswordRequired}
+
+		auth := newTestAuthClient(cognito)
+		config := NewConfig().WithSRPAuth("username", "password").WithChallengeHandler(&testChallengeHandler{})
+		require.NoError(t, auth.SignInContext(context.Background(), config))
+
+		assert.Equal(t, "new_password", cognito.answers[1].ChallengeResponses["NEW_PASSWORD"])
+		assert.Equal(t, "Service Account", cognito.answers[1].ChallengeResponses["userAttributes.name"])
+		assert.Equal(t, "new_password", auth.userConfig.Password)
+		assert.Equal(t, "password", config.Password, "the caller's config must not be modified")
+	})
+
+	t.Run("Wrong codes should fail the sign in", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		cognito.challenges = []types.ChallengeNameType{types.ChallengeNameTypeSmsMfa}
+		cognito.smsCode = "123456"
+
+		auth := newTestAuthClient(cognito)
+		err := auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password").WithChallengeHandler(&testChallengeHandler{smsCode: "000000"}))
+
+		var mismatch *types.CodeMismatchException
+		require.ErrorAs(t, err, &mismatch)
+		assert.Empty(t, auth.GetIDToken())
+	})
+
+	t.Run("Challenges without a handler should be unhandled", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		cognito.challenges = []types.ChallengeNameType{types.ChallengeNameTypeSoftwareTokenMfa}
+
+		auth := newTestAuthClient(cognito)
+		err := auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password"))
+		require.ErrorIs(t, err, ErrUnhandledChallenge)
+		assert.Contains(t, err.Error(), "SOFTWARE_TOKEN_MFA")
+	})
+
+	t.Run("Unknown challenges should be unhandled", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		cognito.challenges = []types.ChallengeNameType{types.ChallengeNameTypeCustomChallenge}
+
+		auth := newTestAuthClient(cognito)
+		err := auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password").WithChallengeHandler(&testChallengeHandler{}))
+		require.ErrorIs(t, err, ErrUnhandledChallenge)
+	})
+
+	t.Run("Endless challenges should be bounded", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		for range maxChallenges + 1 {
+			cognito.challenges = append(cognito.challenges, types.ChallengeNameTypeSmsMfa)
+		}
+		cognito.smsCode = "123456"
+
+		auth := newTestAuthClient(cognito)
+		err := auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password").WithChallengeHandler(&testChallengeHandler{smsCode: "123456"}))
+		require.ErrorContains(t, err, "too many auth challenges")
+	})
+}
+
+// combinedHandler answers MFA with TOTP codes and password changes with a test handler.
+type combinedHandler struct {
+	*TOTPChallengeHandler
+	passwords *testChallengeHandler
+}
+
+func (h *combinedHandler) NewPassword(ctx context.Context, challenge Challenge) (string, map[string]string, error) {
+	return h.passwords.NewPassword(ctx, challenge)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// fakeCognito stands in for the Cognito identity provider API. It answers the SRP flow with arbitrary (but well
+// formed) challenge parameters and accepts any password claim. Refreshes are accepted for the refresh token it
+// issued.
+type fakeCognito struct {
+	cognitoClient // Operations the fake doesn't implement panic if called
+
+	mu            sync.Mutex
+	idToken       string
+	refreshToken  string
+	expiresIn     int32
+	delay         time.Duration // Slows down sign ins to widen race windows
+	rejectRefresh bool          // Rejects all refresh tokens as if revoked
+	signIns       int
+	refreshes     int
+	refreshParams map[string]string // Auth parameters of the last refresh
+
+	challenges []types.ChallengeNameType // Issued in order after the password verifier
+	session    string
+	totpSecret string
+	smsCode    string
+	answers    []*cognitoidentityprovider.RespondToAuthChallengeInput
+}
+
+func newFakeCognito(idToken string) *fakeCognito {
+	return &fakeCognito{idToken: idToken, refreshToken: "refresh_token", expiresIn: 3600}
+}
+
+func (f *fakeCognito) SignIns() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.signIns
+}
+
+func (f *fakeCognito) Refreshes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshes
+}
+
+func (f *fakeCognito) InitiateAuth(ctx context.Context, params *cognitoidentityprovider.InitiateAuthInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.InitiateAuthOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if params.AuthFlow == types.AuthFlowTypeRefreshTokenAuth {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.refreshParams = params.AuthParameters
+
+		if f.rejectRefresh || params.AuthParameters["REFRESH_TOKEN"] != f.refreshToken {
+			return nil, &types.NotAuthorizedException{Message: aws.String("Invalid Refresh Token")}
+		}
+
+		f.refreshes++
+		return &cognitoidentityprovider.InitiateAuthOutput{
+			AuthenticationResult: &types.AuthenticationResultType{
+				IdToken:   aws.String(f.idToken),
+				ExpiresIn: f.expiresIn,
+			},
+		}, nil
+	}
+
+	return &cognitoidentityprovider.InitiateAuthOutput{
+		ChallengeName: types.ChallengeNameTypePasswordVerifier,
+		ChallengeParameters: map[string]string{
+			"USERNAME":        params.AuthParameters["USERNAME"],
+			"USER_ID_FOR_SRP": params.AuthParameters["USERNAME"],
+			"SALT":            "a1b2c3d4e5f6",
+			"SRP_B":           "abcdef0123456789abcdef0123456789",
+			"SECRET_BLOCK":    base64.StdEncoding.EncodeToString([]byte("secret-block")),
+		},
+	}, nil
+}
+
+func (f *fakeCognito) RespondToAuthChallenge(_ context.Context, params *cognitoidentityprovider.RespondToAuthChallengeInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error) {
+	f.mu.Lock()
+	delay := f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers = append(f.answers, params)
+
+	if params.ChallengeName != types.ChallengeNameTypePasswordVerifier {
+		if err := f.checkAnswer(params); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(f.challenges) > 0 {
+		next := f.challenges[0]
+		f.challenges = f.challenges[1:]
+		f.session = "session-" + string(next)
+
+		return &cognitoidentityprovider.RespondToAuthChallengeOutput{
+			ChallengeName:       next,
+			ChallengeParameters: f.challengeParameters(next, params.ChallengeResponses["USERNAME"]),
+			Session:             aws.String(f.session),
+		}, nil
+	}
+
+	f.signIns++
+
+	return &cognitoidentityprovider.RespondToAuthChallengeOutput{
+		AuthenticationResult: &types.AuthenticationResultType{
+			IdToken:      aws.String(f.idToken),
+			RefreshToken: aws.String(f.refreshToken),
+			ExpiresIn:    f.expiresIn,
+		},
+	}, nil
+}
+
+func (f *fakeCognito) challengeParameters(name types.ChallengeNameType, username string) map[string]string {
+	params := map[string]string{"USER_ID_FOR_SRP": username}
+	switch name {
+	case types.ChallengeNameTypeSmsMfa:
+		params["CODE_DELIVERY_DELIVERY_MEDIUM"] = "SMS"
+		params["CODE_DELIVERY_DESTINATION"] = "+*******1234"
+	case types.ChallengeNameTypeSelectMfaType:
+		params["MFAS_CAN_CHOOSE"] = `["SMS_MFA","SOFTWARE_TOKEN_MFA"]`
+	case types.ChallengeNameTypeNewPasswordRequired:
+		params["requiredAttributes"] = `["userAttributes.name"]`
+		params["userAttributes"] = `{"email":"user@example.com"}`
+	}
+	return params
+}
+
+// checkAnswer validates the answer to a challenge issued after the password verifier.
+func (f *fakeCognito) checkAnswer(params *cognitoidentityprovider.RespondToAuthChallengeInput) error {
+	if aws.ToString(params.Session) != f.session {
+		return &types.NotAuthorizedException{Message: aws.String("Invalid session for the user")}
+	}
+
+	responses := params.ChallengeResponses
+	switch params.ChallengeName {
+	case types.ChallengeNameTypeSoftwareTokenMfa:
+		code := responses["SOFTWARE_TOKEN_MFA_CODE"]
+		now := time.Now()
+		for _, t := range []time.Time{now, now.Add(-totpPeriod), now.Add(totpPeriod)} {
+			if expected, _ := TOTP(f.totpSecret, t); code == expected {
+				return nil
+			}
+		}
+		return &types.CodeMismatchException{Message: aws.String("Invalid code received for user")}
+	case types.ChallengeNameTypeSmsMfa:
+		if responses["SMS_MFA_CODE"] != f.smsCode {
+			return &types.CodeMismatchException{Message: aws.String("Invalid code received for user")}
+		}
+	case types.ChallengeNameTypeSelectMfaType:
+		if responses["ANSWER"] != string(types.ChallengeNameTypeSmsMfa) && responses["ANSWER"] != string(types.ChallengeNameTypeSoftwareTokenMfa) {
+			return &types.InvalidParameterException{Message: aws.String("Invalid MFA type")}
+		}
+	case types.ChallengeNameTypeNewPasswordRequired:
+		if responses["NEW_PASSWORD"] == "" || responses["userAttributes.name"] == "" {
+			return &types.InvalidParameterException{Message: aws.String("Missing new password or required attributes")}
+		}
+	}
+	return nil
+}
+
+// newTestJWT returns an unsigned JWT with the claims, in the shape of a Cognito ID token.
+func newTestJWT(claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"test"}`))
+	payload, _ := json.Marshal(claims)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
@@ -0,0 +1,809 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"crypto/hmac"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// cognitoServer is a local stand-in for the Cognito identity provider API, speaking the AWS JSON 1.1 protocol. Unlike
+// fakeCognito it verifies SRP password and device claims, TOTP codes and secret hashes, so it exercises the sign in
+// path end to end.
+type cognitoServer struct {
+	*httptest.Server
+
+	poolID         string
+	clientID       string
+	clientSecret   string // SECRET_HASH is required when set
+	deviceTracking bool   // Issues a device with every sign in not made with one
+	expiresIn      int32  // Lifetime of issued tokens in seconds
+	now            func() time.Time
+
+	mu            sync.Mutex
+	users         map[string]*cognitoUser
+	aliases       map[string]string         // Alias to username, e.g. an email signed in with
+	devices       map[string]*cognitoDevice // Keyed by device key
+	pending       map[string]*srpExchange   // Keyed by SECRET_BLOCK
+	sessions      map[string]*cognitoSession
+	refreshTokens map[string]refreshGrant
+	accessTokens  map[string]issuedToken
+	idTokens      map[string]issuedToken
+	signIns       int
+	refreshes     int
+	mfaChallenges int
+}
+
+type cognitoUser struct {
+	password     string
+	totpSecret   string // Requires SOFTWARE_TOKEN_MFA when set
+	pendingTOTP  string // Associated secret awaiting verification
+	totpVerified bool
+	resetCode    string // Code sent by ForgotPassword
+}
+
+type cognitoDevice struct {
+	username   string
+	groupKey   string
+	salt       *big.Int
+	verifier   *big.Int
+	remembered bool
+}
+
+// srpExchange is the server side of an SRP exchange awaiting the password claim. Device exchanges use the device
+// group key as the pool name and the device key as the user.
+type srpExchange struct {
+	username  string
+	deviceKey string
+	poolName  string
+	userID    string
+	A, b, B   *big.Int
+	verifier  *big.Int
+}
+
+// cognitoSession tracks a sign in between challenges.
+type cognitoSession struct {
+	username  string
+	deviceKey string
+}
+
+type refreshGrant struct {
+	username  string
+	deviceKey string // Refreshes must present the device key the tokens were issued to
+}
+
+// issuedToken is an ID or access token, revoked along with the refresh token it was issued with.
+type issuedToken struct {
+	username     string
+	refreshToken string
+}
+
+func newCognitoServer(t testing.TB, poolID, clientID string) *cognitoServer {
+	s := &cognitoServer{
+		poolID:        poolID,
+		clientID:      clientID,
+		expiresIn:     3600,
+		now:           time.Now,
+		users:         map[string]*cognitoUser{},
+		aliases:       map[string]string{},
+		devices:       map[string]*cognitoDevice{},
+		pending:       map[string]*srpExchange{},
+		sessions:      map[string]*cognitoSession{},
+		refreshTokens: map[string]refreshGrant{},
+		accessTokens:  map[string]issuedToken{},
+		idTokens:      map[string]issuedToken{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddUser registers a user that can sign in with password.
+func (s *cognitoServer) AddUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = &cognitoUser{password: password}
+}
+
+// AddAlias lets a user sign in with alias, e.g. their email, instead of their username.
+func (s *cognitoServer) AddAlias(alias, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aliases[alias] = username
+}
+
+// AddMFAUser registers a user that must also answer SOFTWARE_TOKEN_MFA, unless signing in with a remembered device.
+func (s *cognitoServer) AddMFAUser(username, password, totpSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = &cognitoUser{password: password, totpSecret: totpSecret}
+}
+
+// ResetCode returns the code last sent to the user by ForgotPassword.
+func (s *cognitoServer) ResetCode(username string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[username].resetCode
+}
+
+// ForgetDevice removes a device, as if forgotten from the web UI.
+func (s *cognitoServer) ForgetDevice(deviceKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, deviceKey)
+}
+
+// RememberedDevices returns the number of devices remembered for the user.
+func (s *cognitoServer) RememberedDevices(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, device := range s.devices {
+		if device.username == username && device.remembered {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *cognitoServer) SignIns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signIns
+}
+
+func (s *cognitoServer) Refreshes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes
+}
+
+func (s *cognitoServer) MFAChallenges() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mfaChallenges
+}
+
+// ValidIDToken reports whether the server issued the token to a user.
+func (s *cognitoServer) ValidIDToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.idTokens[token]
+	return ok
+}
+
+// authOptions points an AuthClient at the server.
+func (s *cognitoServer) authOptions() []newAuthClientOptions {
+	opts := []newAuthClientOptions{
+		WithCognitoEndpoint(s.URL),
+		WithUserPoolID(s.poolID),
+		WithClientID(s.clientID),
+	}
+	if s.clientSecret != "" {
+		opts = append(opts, WithClientSecret(s.clientSecret))
+	}
+	return opts
+}
+
+// cognitoError is an AWS JSON protocol error, deserialised by the SDK into the matching types error.
+type cognitoError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *cognitoError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func notAuthorized(message string) *cognitoError {
+	return &cognitoError{Type: "NotAuthorizedException", Message: message}
+}
+
+type cognitoDeviceMetadata struct {
+	DeviceKey      string `json:"DeviceKey"`
+	DeviceGroupKey string `json:"DeviceGroupKey"`
+}
+
+type cognitoAuthResult struct {
+	AccessToken       string                 `json:"AccessToken"`
+	ExpiresIn         int32                  `json:"ExpiresIn"`
+	IdToken           string                 `json:"IdToken"`
+	RefreshToken      string                 `json:"RefreshToken,omitempty"`
+	TokenType         string                 `json:"TokenType"`
+	NewDeviceMetadata *cognitoDeviceMetadata `json:"NewDeviceMetadata,omitempty"`
+}
+
+type cognitoAuthResponse struct {
+	AuthenticationResult *cognitoAuthResult `json:"AuthenticationResult,omitempty"`
+	ChallengeName        string             `json:"ChallengeName,omitempty"`
+	ChallengeParameters  map[string]string  `json:"ChallengeParameters,omitempty"`
+	Session              string             `json:"Session,omitempty"`
+}
+
+// cognitoInput holds the fields of every operation the server implements.
+type cognitoInput struct {
+	AuthFlow           string
+	ClientId           string
+	AuthParameters     map[string]string
+	ChallengeName      string
+	ChallengeResponses map[string]string
+	Session            string
+
+	AccessToken                string
+	Token                      string
+	ClientSecret               string
+	DeviceKey                  string
+	DeviceName                 string
+	DeviceRememberedStatus     string
+	DeviceSecretVerifierConfig struct {
+		PasswordVerifier string
+		Salt             string
+	}
+
+	Username                 string
+	SecretHash               string
+	PreviousPassword         string
+	ProposedPassword         string
+	Password                 string
+	ConfirmationCode         string
+	UserCode                 string
+	SoftwareTokenMfaSettings struct {
+		Enabled      bool
+		PreferredMfa bool
+	}
+}
+
+func (s *cognitoServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	operation, ok := strings.CutPrefix(r.Header.Get("X-Amz-Target"), "AWSCognitoIdentityProviderService.")
+	if r.Method != http.MethodPost || !ok {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	var input cognitoInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		s.writeError(w, &cognitoError{Type: "SerializationException", Message: err.Error()})
+		return
+	}
+	if input.ClientId != "" && input.ClientId != s.clientID {
+		s.writeError(w, &cognitoError{Type: "ResourceNotFoundException", Message: "User pool client does not exist."})
+		return
+	}
+
+	var (
+		response any
+		err      error
+	)
+	s.mu.Lock()
+	switch {
+	case operation == "InitiateAuth" && input.AuthFlow == "USER_SRP_AUTH":
+		response, err = s.initiateSRP(input.AuthParameters)
+	case operation == "InitiateAuth" && input.AuthFlow == "REFRESH_TOKEN_AUTH":
+		response, err = s.refresh(input.AuthParameters)
+	case operation == "RespondToAuthChallenge":
+		response, err = s.respondToChallenge(&input)
+	case operation == "ConfirmDevice":
+		response, err = s.confirmDevice(&input)
+	case operation == "UpdateDeviceStatus":
+		response, err = s.updateDeviceStatus(&input)
+	case operation == "RevokeToken":
+		response, err = s.revokeToken(&input)
+	case operation == "GlobalSignOut":
+		response, err = s.globalSignOut(&input)
+	case operation == "ChangePassword":
+		response, err = s.changePassword(&input)
+	case operation == "ForgotPassword":
+		response, err = s.forgotPassword(&input)
+	case operation == "ConfirmForgotPassword":
+		response, err = s.confirmForgotPassword(&input)
+	case operation == "AssociateSoftwareToken":
+		response, err = s.associateSoftwareToken(&input)
+	case operation == "VerifySoftwareToken":
+		response, err = s.verifySoftwareToken(&input)
+	case operation == "SetUserMFAPreference":
+		response, err = s.setUserMFAPreference(&input)
+	default:
+		err = &cognitoError{Type: "InvalidParameterException", Message: "unsupported " + operation + " " + input.AuthFlow}
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (s *cognitoServer) writeError(w http.ResponseWriter, err error) {
+	cerr, ok := err.(*cognitoError)
+	if !ok {
+		cerr = &cognitoError{Type: "InternalErrorException", Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(cerr)
+}
+
+func (s *cognitoServer) checkSecretHash(username, hash string) error {
+	if s.clientSecret == "" {
+		return nil
+	}
+	if hash != secretHash(username, s.clientID, s.clientSecret) {
+		return notAuthorized("Client " + s.clientID + " is configured for secret but secret was not received")
+	}
+	return nil
+}
+
+func (s *cognitoServer) initiateSRP(params map[string]string) (any, error) {
+	username := params["USERNAME"]
+	if err := s.checkSecretHash(username, params["SECRET_HASH"]); err != nil {
+		return nil, err
+	}
+	// Hashes are computed from the name signed in with, the rest of the flow uses the username
+	if aliased, ok := s.aliases[username]; ok {
+		username = aliased
+	}
+	user, ok := s.users[username]
+	if !ok {
+		return nil, &cognitoError{Type: "UserNotFoundException", Message: "User does not exist."}
+	}
+	if deviceKey := params["DEVICE_KEY"]; deviceKey != "" {
+		if _, ok := s.devices[deviceKey]; !ok {
+			return nil, &cognitoError{Type: "ResourceNotFoundException", Message: "Device does not exist."}
+		}
+	}
+
+	salt := randomTestInt()
+	verifier := new(big.Int).Exp(srpG, srpPasswordExponent(s.poolName(), username, user.password, salt), srpN)
+	exchange := &srpExchange{username: username, poolName: s.poolName(), userID: username, verifier: verifier}
+	parameters, err := s.startExchange(exchange, params["SRP_A"], salt)
+	if err != nil {
+		return nil, err
+	}
+	parameters["USERNAME"] = username
+	parameters["USER_ID_FOR_SRP"] = username
+
+	return cognitoAuthResponse{ChallengeName: "PASSWORD_VERIFIER", ChallengeParameters: parameters}, nil
+}
+
+// startExchange computes B = k * v + g^b and returns the challenge parameters for the client.
+func (s *cognitoServer) startExchange(exchange *srpExchange, srpA string, salt *big.Int) (map[string]string, error) {
+	A, ok := new(big.Int).SetString(srpA, 16)
+	if !ok || new(big.Int).Mod(A, srpN).Sign() == 0 {
+		return nil, &cognitoError{Type: "InvalidParameterException", Message: "invalid SRP_A"}
+	}
+
+	exchange.A = A
+	exchange.b = randomTestInt()
+	exchange.B = new(big.Int).Add(new(big.Int).Mul(srpK, exchange.verifier), new(big.Int).Exp(srpG, exchange.b, srpN))
+	exchange.B.Mod(exchange.B, srpN)
+
+	block := base64.StdEncoding.EncodeToString(randomTestInt().Bytes())
+	s.pending[block] = exchange
+
+	return map[string]string{
+		"SALT":         salt.Text(16),
+		"SRP_B":        exchange.B.Text(16),
+		"SECRET_BLOCK": block,
+	}, nil
+}
+
+// verifyClaim checks the password claim of an exchange, computing S = (A * v^u)^b mod N to match the client's
+// (B - k * g^x)^(a + u * x).
+func (s *cognitoServer) verifyClaim(responses map[string]string) (*srpExchange, error) {
+	block := responses["PASSWORD_CLAIM_SECRET_BLOCK"]
+	exchange, ok := s.pending[block]
+	if !ok {
+		return nil, notAuthorized("Invalid session for the user.")
+	}
+	delete(s.pending, block)
+
+	if err := s.checkSecretHash(exchange.username, responses["SECRET_HASH"]); err != nil {
+		return nil, err
+	}
+
+	u := srpU(exchange.A, exchange.B)
+	S := new(big.Int).Mul(exchange.A, new(big.Int).Exp(exchange.verifier, u, srpN))
+	S.Exp(S, exchange.b, srpN)
+
+	secretBlock, _ := base64.StdEncoding.DecodeString(block)
+	expected := srpSignature(srpKey(S, u), exchange.poolName, exchange.userID, secretBlock, responses["TIMESTAMP"])
+	if !hmac.Equal([]byte(responses["PASSWORD_CLAIM_SIGNATURE"]), []byte(expected)) {
+		return nil, notAuthorized("Incorrect username or password.")
+	}
+	return exchange, nil
+}
+
+func (s *cognitoServer) respondToChallenge(input *cognitoInput) (any, error) {
+	responses := input.ChallengeResponses
+
+	switch input.ChallengeName {
+	case "PASSWORD_VERIFIER":
+		exchange, err := s.verifyClaim(responses)
+		if err != nil {
+			return nil, err
+		}
+		return s.afterPassword(exchange.username, responses["DEVICE_KEY"]), nil
+
+	case "SOFTWARE_TOKEN_MFA":
+		session, ok := s.sessions[input.Session]
+		if !ok {
+			return nil, notAuthorized("Invalid session for the user.")
+		}
+		delete(s.sessions, input.Session)
+
+		// Like Cognito, accept the previous code too in case the period rolled over in flight
+		secret := s.users[session.username].totpSecret
+		current, _ := TOTP(secret, s.now())
+		previous, _ := TOTP(secret, s.now().Add(-totpPeriod))
+		if code := responses["SOFTWARE_TOKEN_MFA_CODE"]; code != current && code != previous {
+			return nil, &cognitoError{Type: "CodeMismatchException", Message: "Invalid code received for user"}
+		}
+		return s.complete(session.username, ""), nil
+
+	case "DEVICE_SRP_AUTH":
+		session, ok := s.sessions[input.Session]
+		if !ok {
+			return nil, notAuthorized("Invalid session for the user.")
+		}
+		delete(s.sessions, input.Session)
+
+		device, ok := s.devices[responses["DEVICE_KEY"]]
+		if !ok || device.username != session.username || responses["DEVICE_KEY"] != session.deviceKey {
+			return nil, &cognitoError{Type: "ResourceNotFoundException", Message: "Device does not exist."}
+		}
+		if err := s.checkSecretHash(session.username, responses["SECRET_HASH"]); err != nil {
+			return nil, err
+		}
+
+		exchange := &srpExchange{
+			username:  session.username,
+			deviceKey: session.deviceKey,
+			poolName:  device.groupKey,
+			userID:    session.deviceKey,
+			verifier:  device.verifier,
+		}
+		parameters, err := s.startExchange(exchange, responses["SRP_A"], device.salt)
+		if err != nil {
+			return nil, err
+		}
+		parameters["USERNAME"] = session.username
+		parameters["DEVICE_KEY"] = session.deviceKey
+
+		return cognitoAuthResponse{
+			ChallengeName:       "DEVICE_PASSWORD_VERIFIER",
+			ChallengeParameters: parameters,
+			Session:             s.newSession(session.username, session.deviceKey),
+		}, nil
+
+	case "DEVICE_PASSWORD_VERIFIER":
+		exchange, err := s.verifyClaim(responses)
+		if err != nil {
+			return nil, err
+		}
+		if exchange.deviceKey == "" {
+			return nil, notAuthorized("Invalid session for the user.")
+		}
+		return s.complete(exchange.username, exchange.deviceKey), nil
+	}
+
+	return nil, &cognitoError{Type: "InvalidParameterException", Message: "unsupported challenge " + input.ChallengeName}
+}
+
+// afterPassword issues the challenge following a verified password: device authentication for a remembered
+// device, otherwise MFA if the user has it enabled.
+func (s *cognitoServer) afterPassword(username, deviceKey string) cognitoAuthResponse {
+	if device, ok := s.devices[deviceKey]; ok && device.username == username && device.remembered {
+		return cognitoAuthResponse{
+			ChallengeName:       "DEVICE_SRP_AUTH",
+			ChallengeParameters: map[string]string{"USERNAME": username},
+			Session:             s.newSession(username, deviceKey),
+		}
+	}
+
+	if s.users[username].totpSecret != "" {
+		s.mfaChallenges++
+		return cognitoAuthResponse{
+			ChallengeName:       "SOFTWARE_TOKEN_MFA",
+			ChallengeParameters: map[string]string{"USERNAME": username},
+			Session:             s.newSession(username, ""),
+		}
+	}
+
+	return s.complete(username, "")
+}
+
+func (s *cognitoServer) newSession(username, deviceKey string) string {
+	session := "session-" + randomTestInt().Text(16)
+	s.sessions[session] = &cognitoSession{username: username, deviceKey: deviceKey}
+	return session
+}
+
+// complete finishes a sign in. Sign ins without a device are issued a new one when device tracking is on.
+func (s *cognitoServer) complete(username, deviceKey string) cognitoAuthResponse {
+	s.signIns++
+	refreshToken := "refresh-" + randomTestInt().Text(16)
+	result := s.issueTokens(username, refreshToken)
+
+	if deviceKey == "" && s.deviceTracking {
+		deviceKey = strings.SplitN(s.poolID, "_", 2)[0] + "_" + randomTestInt().Text(16)
+		s.devices[deviceKey] = &cognitoDevice{username: username, groupKey: "-" + randomTestInt().Text(16)[:8]}
+		result.NewDeviceMetadata = &cognitoDeviceMetadata{DeviceKey: deviceKey, DeviceGroupKey: s.devices[deviceKey].groupKey}
+	}
+
+	result.RefreshToken = refreshToken
+	s.refreshTokens[refreshToken] = refreshGrant{username: username, deviceKey: deviceKey}
+	return cognitoAuthResponse{AuthenticationResult: result}
+}
+
+func (s *cognitoServer) refresh(params map[string]string) (any, error) {
+	grant, ok := s.refreshTokens[params["REFRESH_TOKEN"]]
+	if !ok || grant.deviceKey != params["DEVICE_KEY"] {
+		return nil, notAuthorized("Invalid Refresh Token")
+	}
+	if err := s.checkSecretHash(grant.username, params["SECRET_HASH"]); err != nil {
+		return nil, err
+	}
+
+	s.refreshes++
+	return cognitoAuthResponse{AuthenticationResult: s.issueTokens(grant.username, params["REFRESH_TOKEN"])}, nil
+}
+
+// revokeToken revokes a refresh token and the tokens issued with it. Unknown tokens are ignored, as by Cognito.
+func (s *cognitoServer) revokeToken(input *cognitoInput) (any, error) {
+	if s.clientSecret != "" && input.ClientSecret != s.clientSecret {
+		return nil, notAuthorized("Client secret is not valid")
+	}
+
+	delete(s.refreshTokens, input.Token)
+	s.revokeIssued(func(token issuedToken) bool { return token.refreshToken == input.Token })
+	return struct{}{}, nil
+}
+
+// globalSignOut revokes every token issued to the user.
+func (s *cognitoServer) globalSignOut(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	for token, grant := range s.refreshTokens {
+		if grant.username == username {
+			delete(s.refreshTokens, token)
+		}
+	}
+	s.revokeIssued(func(token issuedToken) bool { return token.username == username })
+	return struct{}{}, nil
+}
+
+func (s *cognitoServer) changePassword(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	user := s.users[username]
+	if input.PreviousPassword != user.password {
+		return nil, notAuthorized("Incorrect username or password.")
+	}
+	if err := checkPasswordPolicy(input.ProposedPassword); err != nil {
+		return nil, err
+	}
+
+	user.password = input.ProposedPassword
+	return struct{}{}, nil
+}
+
+func (s *cognitoServer) forgotPassword(input *cognitoInput) (any, error) {
+	if err := s.checkSecretHash(input.Username, input.SecretHash); err != nil {
+		return nil, err
+	}
+	user, ok := s.users[input.Username]
+	if !ok {
+		return nil, &cognitoError{Type: "UserNotFoundException", Message: "Username/client id combination not found."}
+	}
+
+	user.resetCode = fmt.Sprintf("%06d", randomTestInt().Int64()%1_000_000)
+	return map[string]any{"CodeDeliveryDetails": map[string]string{
+		"AttributeName":  "email",
+		"DeliveryMedium": "EMAIL",
+		"Destination":    input.Username[:1] + "***@e***",
+	}}, nil
+}
+
+func (s *cognitoServer) confirmForgotPassword(input *cognitoInput) (any, error) {
+	if err := s.checkSecretHash(input.Username, input.SecretHash); err != nil {
+		return nil, err
+	}
+	user, ok := s.users[input.Username]
+	if !ok {
+		return nil, &cognitoError{Type: "UserNotFoundException", Message: "Username/client id combination not found."}
+	}
+	if user.resetCode == "" || input.ConfirmationCode != user.resetCode {
+		return nil, &cognitoError{Type: "CodeMismatchException", Message: "Invalid verification code provided, please try again."}
+	}
+	if err := checkPasswordPolicy(input.Password); err != nil {
+		return nil, err
+	}
+
+	user.password = input.Password
+	user.resetCode = ""
+	return struct{}{}, nil
+}
+
+func (s *cognitoServer) associateSoftwareToken(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomTestInt().Bytes())
+	user := s.users[username]
+	user.pendingTOTP = secret
+	user.totpVerified = false
+	return map[string]string{"SecretCode": secret}, nil
+}
+
+func (s *cognitoServer) verifySoftwareToken(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	user := s.users[username]
+	if user.pendingTOTP == "" {
+		return nil, &cognitoError{Type: "InvalidParameterException", Message: "No software token associated"}
+	}
+
+	code, _ := TOTP(user.pendingTOTP, s.now())
+	if input.UserCode != code {
+		return nil, &cognitoError{Type: "EnableSoftwareTokenMFAException", Message: "Code mismatch"}
+	}
+
+	user.totpVerified = true
+	return map[string]string{"Status": "SUCCESS"}, nil
+}
+
+func (s *cognitoServer) setUserMFAPreference(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	user := s.users[username]
+
+	if input.SoftwareTokenMfaSettings.Enabled {
+		if !user.totpVerified {
+			return nil, &cognitoError{Type: "InvalidParameterException", Message: "User has not verified software token mfa"}
+		}
+		user.totpSecret = user.pendingTOTP
+	} else {
+		user.totpSecret = ""
+	}
+	return struct{}{}, nil
+}
+
+// checkPasswordPolicy applies a minimal password policy.
+func checkPasswordPolicy(password string) error {
+	if len(password) < 8 {
+		return &cognitoError{Type: "InvalidPasswordException", Message: "Password did not conform with policy: Password not long enough"}
+	}
+	return nil
+}
+
+func (s *cognitoServer) revokeIssued(match func(issuedToken) bool) {
+	for _, tokens := range []map[string]issuedToken{s.idTokens, s.accessTokens} {
+		for token, issued := range tokens {
+			if match(issued) {
+				delete(tokens, token)
+			}
+		}
+	}
+}
+
+func (s *cognitoServer) accessTokenUser(accessToken string) (string, error) {
+	token, ok := s.accessTokens[accessToken]
+	if !ok {
+		return "", notAuthorized("Access Token has been revoked")
+	}
+	return token.username, nil
+}
+
+func (s *cognitoServer) confirmDevice(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	device, ok := s.devices[input.DeviceKey]
+	if !ok || device.username != username {
+		return nil, &cognitoError{Type: "ResourceNotFoundException", Message: "Device does not exist."}
+	}
+
+	verifier, err := base64.StdEncoding.DecodeString(input.DeviceSecretVerifierConfig.PasswordVerifier)
+	if err != nil {
+		return nil, &cognitoError{Type: "InvalidParameterException", Message: "invalid password verifier"}
+	}
+	salt, err := base64.StdEncoding.DecodeString(input.DeviceSecretVerifierConfig.Salt)
+	if err != nil {
+		return nil, &cognitoError{Type: "InvalidParameterException", Message: "invalid salt"}
+	}
+	device.verifier = new(big.Int).SetBytes(verifier)
+	device.salt = new(big.Int).SetBytes(salt)
+
+	return map[string]bool{"UserConfirmationNecessary": true}, nil
+}
+
+func (s *cognitoServer) updateDeviceStatus(input *cognitoInput) (any, error) {
+	username, err := s.accessTokenUser(input.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	device, ok := s.devices[input.DeviceKey]
+	if !ok || device.username != username || device.verifier == nil {
+		return nil, &cognitoError{Type: "ResourceNotFoundException", Message: "Device does not exist."}
+	}
+
+	device.remembered = input.DeviceRememberedStatus == "remembered"
+	return struct{}{}, nil
+}
+
+// issueTokens returns new ID and access tokens for the user, issued with the refresh token.
+func (s *cognitoServer) issueTokens(username, refreshToken string) *cognitoAuthResult {
+	now := s.now()
+	idToken := newTestJWT(map[string]any{
+		"sub":              "sub-" + username,
+		"email":            username,
+		"cognito:username": username,
+		"token_use":        "id",
+		"iss":              s.URL + "/" + s.poolID,
+		"iat":              now.Unix(),
+		"exp":              now.Unix() + int64(s.expiresIn),
+		"jti":              randomTestInt().Text(16),
+	})
+	s.idTokens[idToken] = issuedToken{username: username, refreshToken: refreshToken}
+
+	accessToken := "access-" + randomTestInt().Text(16)
+	s.accessTokens[accessToken] = issuedToken{username: username, refreshToken: refreshToken}
+
+	return &cognitoAuthResult{
+		AccessToken: accessToken,
+		ExpiresIn:   s.expiresIn,
+		IdToken:     idToken,
+		TokenType:   "Bearer",
+	}
+}
+
+func (s *cognitoServer) poolName() string {
+	_, name, _ := strings.Cut(s.poolID, "_")
+	return name
+}
+
+func randomTestInt() *big.Int {
+	n, err := randomInt(32)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
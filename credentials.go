@@ -0,0 +1,300 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EnvUsername        = "SDK_ALTA_USER"
+	EnvPassword        = "SDK_ALTA_PASS"
+	EnvIDToken         = "SDK_ALTA_ID_TOKEN"
+	EnvRefreshToken    = "SDK_ALTA_REFRESH_TOKEN"
+	EnvCredentialsFile = "SDK_ALTA_CREDENTIALS_FILE" // Overrides the default credentials file path
+	EnvProfile         = "SDK_ALTA_PROFILE"          // Selects the profile in the credentials file
+
+	DefaultProfile = "default"
+)
+
+// ErrNoCredentials is returned by a CredentialsProvider that has no credentials to offer, so a chain moves on to
+// the next provider.
+var ErrNoCredentials = errors.New("no credentials found")
+
+// Credentials are either a username and password to sign in with, or tokens from an earlier sign in. Tokens are
+// used in preference to the password, which is kept to sign in again once they are rejected.
+type Credentials struct {
+	Username     string
+	Password     string
+	IDToken      string
+	RefreshToken string
+	Source       string // Name of the provider the credentials came from
+}
+
+// HasPassword reports whether the credentials can sign in with SRP.
+func (c Credentials) HasPassword() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// HasTokens reports whether the credentials include tokens from an earlier sign in.
+func (c Credentials) HasTokens() bool {
+	return c.IDToken != "" || c.RefreshToken != ""
+}
+
+// CredentialsProvider resolves the credentials used to sign in, modelled on the AWS SDK's providers.
+type CredentialsProvider interface {
+	// Retrieve returns the credentials, or an error wrapping ErrNoCredentials if the provider has none.
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsProviderFunc adapts a function to a CredentialsProvider.
+type CredentialsProviderFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialsProviderFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+func (f CredentialsProviderFunc) String() string {
+	return "func"
+}
+
+// StaticCredentialsProvider returns fixed credentials.
+type StaticCredentialsProvider struct {
+	Credentials Credentials
+}
+
+// NewStaticCredentialsProvider returns a provider for a fixed username and password.
+func NewStaticCredentialsProvider(username, password string) StaticCredentialsProvider {
+	return StaticCredentialsProvider{Credentials: Credentials{Username: username, Password: password}}
+}
+
+func (p StaticCredentialsProvider) Retrieve(context.Context) (Credentials, error) {
+	creds := p.Credentials
+	if !creds.HasPassword() && !creds.HasTokens() {
+		return Credentials{}, fmt.Errorf("%w: static credentials are empty", ErrNoCredentials)
+	}
+
+	creds.Source = p.String()
+	return creds, nil
+}
+
+func (p StaticCredentialsProvider) String() string {
+	return "static"
+}
+
+// EnvCredentialsProvider reads credentials from the SDK_ALTA_USER and SDK_ALTA_PASS environment variables, or
+// tokens from SDK_ALTA_ID_TOKEN and SDK_ALTA_REFRESH_TOKEN.
+type EnvCredentialsProvider struct{}
+
+func (p EnvCredentialsProvider) Retrieve(context.Context) (Credentials, error) {
+	creds := Credentials{
+		Username:     os.Getenv(EnvUsername),
+		Password:     os.Getenv(EnvPassword),
+		IDToken:      os.Getenv(EnvIDToken),
+		RefreshToken: os.Getenv(EnvRefreshToken),
+		Source:       p.String(),
+	}
+	if !creds.HasPassword() && !creds.HasTokens() {
+		return Credentials{}, fmt.Errorf("%w: neither %s and %s nor %s or %s are set", ErrNoCredentials, EnvUsername,
+			EnvPassword, EnvIDToken, EnvRefreshToken)
+	}
+
+	return creds, nil
+}
+
+func (p EnvCredentialsProvider) String() string {
+	return "env"
+}
+
+// FileCredentialsProvider reads credentials from a profile in an INI style credentials file:
+//
+//	[default]
+//	username = user@example.com
+//	password = secret
+//
+//	[service]
+//	refresh_token = eyJjdHkiOiJKV1Qi...
+//
+// Keys are username, password, id_token and refresh_token. Lines starting with # or ; are comments.
+type FileCredentialsProvider struct {
+	Path    string // Defaults to SDK_ALTA_CREDENTIALS_FILE, then ~/.config/altalabs/credentials
+	Profile string // Defaults to SDK_ALTA_PROFILE, then "default"
+}
+
+// DefaultCredentialsFile returns the default path of the credentials file, ~/.config/altalabs/credentials.
+func DefaultCredentialsFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "altalabs", "credentials"), nil
+}
+
+func (p FileCredentialsProvider) path() (string, error) {
+	if p.Path != "" {
+		return p.Path, nil
+	}
+	if path := os.Getenv(EnvCredentialsFile); path != "" {
+		return path, nil
+	}
+	return DefaultCredentialsFile()
+}
+
+func (p FileCredentialsProvider) profile() string {
+	if p.Profile != "" {
+		return p.Profile
+	}
+	if profile := os.Getenv(EnvProfile); profile != "" {
+		return profile
+	}
+	return DefaultProfile
+}
+
+func (p FileCredentialsProvider) Retrieve(context.Context) (Credentials, error) {
+	path, err := p.path()
+	if err != nil {
+		return Credentials{}, err
+	}
+	profile := p.profile()
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Credentials{}, fmt.Errorf("%w: %s does not exist", ErrNoCredentials, path)
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to open credentials file: %w", err)
+	}
+	defer file.Close()
+
+	values, err := readINISection(file, profile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read credentials file %s: %w", path, err)
+	}
+	if values == nil {
+		return Credentials{}, fmt.Errorf("%w: profile %q not found in %s", ErrNoCredentials, profile, path)
+	}
+
+	creds := Credentials{
+		Username:     values["username"],
+		Password:     values["password"],
+		IDToken:      values["id_token"],
+		RefreshToken: values["refresh_token"],
+		Source:       p.String(),
+	}
+	if !creds.HasPassword() && !creds.HasTokens() {
+		return Credentials{}, fmt.Errorf("%w: profile %q in %s has no password or tokens", ErrNoCredentials, profile, path)
+	}
+
+	return creds, nil
+}
+
+func (p FileCredentialsProvider) String() string {
+	return "file (profile " + p.profile() + ")"
+}
+
+// readINISection returns the key value pairs of a section, or nil if the section isn't present.
+func readINISection(r io.Reader, section string) (map[string]string, error) {
+	var values map[string]string
+	current := ""
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: malformed section", line)
+			}
+			current = strings.TrimSpace(text[1 : len(text)-1])
+			if current == section && values == nil {
+				values = map[string]string{}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", line)
+		}
+		if current == section {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// ChainCredentialsProvider tries each provider in turn, returning the first credentials found. A provider failing
+// with anything other than ErrNoCredentials stops the chain, as it indicates broken configuration rather than
+// absent configuration.
+type ChainCredentialsProvider struct {
+	Providers []CredentialsProvider
+}
+
+// NewChainCredentialsProvider returns a provider trying each of the providers in order.
+func NewChainCredentialsProvider(providers ...CredentialsProvider) *ChainCredentialsProvider {
+	return &ChainCredentialsProvider{Providers: providers}
+}
+
+// DefaultCredentialsChain returns the default chain: environment variables, then the credentials file.
+func DefaultCredentialsChain() *ChainCredentialsProvider {
+	return NewChainCredentialsProvider(EnvCredentialsProvider{}, FileCredentialsProvider{})
+}
+
+func (c *ChainCredentialsProvider) Retrieve(ctx context.Context) (Credentials, error) {
+	tried := make([]string, 0, len(c.Providers))
+	var errs []error
+
+	for _, provider := range c.Providers {
+		name := providerName(provider)
+		tried = append(tried, name)
+
+		creds, err := provider.Retrieve(ctx)
+		if err == nil {
+			if creds.Source == "" {
+				creds.Source = name
+			}
+			return creds, nil
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			return Credentials{}, fmt.Errorf("credentials provider %s failed: %w", name, err)
+		}
+		errs = append(errs, err)
+	}
+
+	return Credentials{}, fmt.Errorf("%w, tried: %s: %w", ErrNoCredentials, strings.Join(tried, ", "), errors.Join(errs...))
+}
+
+func providerName(provider CredentialsProvider) string {
+	if stringer, ok := provider.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", provider)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCredentialsFile = `
+# Comments and blank lines are ignored
+[default]
+username = user@example.com
+password = p@ss=word
+
+; Profiles may carry tokens instead of a password
+[service]
+refresh_token = refresh_token
+
+[empty]
+`
+
+func clearCredentialsEnv(t *testing.T) {
+	for _, name := range []string{EnvUsername, EnvPassword, EnvIDToken, EnvRefreshToken, EnvCredentialsFile, EnvProfile} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestCredentialsProviders(t *testing.T) {
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(path, []byte(testCredentialsFile), 0o600))
+
+	t.Run("Static", func(t *testing.T) {
+		creds, err := NewStaticCredentialsProvider("user", "pass").Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Credentials{Username: "user", Password: "pass", Source: "static"}, creds)
+
+		_, err = NewStaticCredentialsProvider("", "").Retrieve(ctx)
+		require.ErrorIs(t, err, ErrNoCredentials)
+
+		creds, err = StaticCredentialsProvider{Credentials: Credentials{RefreshToken: "refresh_token"}}.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.True(t, creds.HasTokens())
+		assert.False(t, creds.HasPassword())
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		clearCredentialsEnv(t)
+		_, err := EnvCredentialsProvider{}.Retrieve(ctx)
+		require.ErrorIs(t, err, ErrNoCredentials)
+
+		t.Setenv(EnvUsername, "user")
+		_, err = EnvCredentialsProvider{}.Retrieve(ctx)
+		require.ErrorIs(t, err, ErrNoCredentials, "a username alone isn't enough")
+
+		t.Setenv(EnvPassword, "pass")
+		creds, err := EnvCredentialsProvider{}.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Credentials{Username: "user", Password: "pass", Source: "env"}, creds)
+
+		t.Setenv(EnvRefreshToken, "refresh_token")
+		creds, err = EnvCredentialsProvider{}.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh_token", creds.RefreshToken)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		clearCredentialsEnv(t)
+
+		creds, err := FileCredentialsProvider{Path: path}.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Credentials{Username: "user@example.com", Password: "p@ss=word", Source: "file (profile default)"}, creds)
+
+		creds, err = FileCredentialsProvider{Path: path, Profile: "service"}.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh_token", creds.RefreshToken)
+
+		t.Setenv(EnvCredentialsFile, path)
+		t.Setenv(EnvProfile, "service")
+		creds, err = FileCredentialsProvider{}.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh_token", creds.RefreshToken)
+
+		for _, provider := range []FileCredentialsProvider{
+			{Path: path, Profile: "missing"},
+			{Path: path, Profile: "empty"},
+			{Path: filepath.Join(t.TempDir(), "missing")},
+		} {
+			_, err = provider.Retrieve(ctx)
+			require.ErrorIs(t, err, ErrNoCredentials, provider)
+		}
+	})
+
+	t.Run("Malformed files should error", func(t *testing.T) {
+		malformed := filepath.Join(t.TempDir(), "credentials")
+		require.NoError(t, os.WriteFile(malformed, []byte("[default\nusername = user\n"), 0o600))
+
+		_, err := FileCredentialsProvider{Path: malformed}.Retrieve(ctx)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNoCredentials)
+	})
+
+	t.Run("Func", func(t *testing.T) {
+		provider := CredentialsProviderFunc(func(context.Context) (Credentials, error) {
+			return Credentials{Username: "user", Password: "pass"}, nil
+		})
+		creds, err := NewChainCredentialsProvider(provider).Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "func", creds.Source)
+	})
+}
+
+func TestChainCredentialsProvider(t *testing.T) {
+	ctx := context.Background()
+	empty := NewStaticCredentialsProvider("", "")
+
+	t.Run("The first provider with credentials should win", func(t *testing.T) {
+		chain := NewChainCredentialsProvider(empty, NewStaticCredentialsProvider("first", "pass"),
+			NewStaticCredentialsProvider("second", "pass"))
+
+		creds, err := chain.Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "first", creds.Username)
+	})
+
+	t.Run("Errors should list the providers tried", func(t *testing.T) {
+		clearCredentialsEnv(t)
+		chain := NewChainCredentialsProvider(EnvCredentialsProvider{},
+			FileCredentialsProvider{Path: filepath.Join(t.TempDir(), "missing"), Profile: "ci"}, empty)
+
+		_, err := chain.Retrieve(ctx)
+		require.ErrorIs(t, err, ErrNoCredentials)
+		assert.Contains(t, err.Error(), "tried: env, file (profile ci), static")
+		assert.Contains(t, err.Error(), "SDK_ALTA_USER")
+		assert.Contains(t, err.Error(), "SDK_ALTA_ID_TOKEN")
+	})
+
+	t.Run("Broken providers should stop the chain", func(t *testing.T) {
+		broken := errors.New("vault unavailable")
+		chain := NewChainCredentialsProvider(CredentialsProviderFunc(func(context.Context) (Credentials, error) {
+			return Credentials{}, broken
+		}), NewStaticCredentialsProvider("user", "pass"))
+
+		_, err := chain.Retrieve(ctx)
+		require.ErrorIs(t, err, broken)
+		assert.Contains(t, err.Error(), "func")
+	})
+}
+
+func TestSignInWithTokens(t *testing.T) {
+	ctx := context.Background()
+	newTestAuthClient := func(cognito cognitoClient) *AuthClient {
+		return &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			cognito:    cognito,
+		}
+	}
+
+	current := newTestJWT(map[string]any{"cognito:username": "user", "exp": time.Now().Add(time.Hour).Unix()})
+	expired := newTestJWT(map[string]any{"cognito:username": "user", "exp": time.Now().Add(-time.Hour).Unix()})
+
+	t.Run("A current ID token should be used as is", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(cognito)
+
+		require.NoError(t, auth.SignInWithTokens(ctx, nil, current, "refresh_token"))
+		assert.Equal(t, current, auth.GetIDToken())
+		assert.Equal(t, 0, cognito.SignIns()+cognito.Refreshes())
+		assert.Equal(t, "user", auth.userConfig.Username)
+	})
+
+	t.Run("An expired ID token should be refreshed", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(cognito)
+
+		require.NoError(t, auth.SignInWithTokens(ctx, nil, expired, "refresh_token"))
+		assert.Equal(t, "id_token", auth.GetIDToken())
+		assert.Equal(t, 1, cognito.Refreshes())
+
+		// The refresh token must survive for the next refresh
+		require.NoError(t, auth.RefreshAuthContext(ctx))
+		assert.Equal(t, 2, cognito.Refreshes())
+	})
+
+	t.Run("A rejected refresh token should fall back to the password", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(cognito)
+
+		require.NoError(t, auth.SignInWithTokens(ctx, NewConfig().WithSRPAuth("user", "pass"), "", "revoked"))
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+
+	t.Run("Expired tokens without a password should require re-authentication", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+
+		require.ErrorIs(t, newTestAuthClient(cognito).SignInWithTokens(ctx, nil, "", "revoked"), ErrReauthRequired)
+		require.ErrorIs(t, newTestAuthClient(cognito).SignInWithTokens(ctx, nil, expired, ""), ErrReauthRequired)
+	})
+
+	t.Run("Malformed ID tokens should error", func(t *testing.T) {
+		err := newTestAuthClient(newFakeCognito("id_token")).SignInWithTokens(ctx, nil, "not-a-jwt", "")
+		require.Error(t, err)
+	})
+}
@@ -1,5 +1,7 @@
 package altalabs
 
+import "context"
+
 type Devices []Device
 
 type Device struct {
@@ -20,10 +22,10 @@ type Device struct {
 }
 
 type ListDeviceRequest struct {
-	SiteName string `json:"siteName"`
+	SiteName string `json:"siteName" url:"siteName"`
 }
 
-func (a *AltaClient) ListDevices(siteName string) (Devices, error) {
+func (a *AltaClient) ListDevicesContext(ctx context.Context, siteName string) (Devices, error) {
 	siteURL := "device/list"
 
 	req := ListDeviceRequest{
@@ -32,23 +34,33 @@ func (a *AltaClient) ListDevices(siteName string) (Devices, error) {
 
 	var devices = make(Devices, 0)
 
-	if err := a.getRequest(siteURL, req, &devices); err != nil {
+	if err := a.getRequest(ctx, "devices.list", siteURL, req, &devices); err != nil {
 		return nil, err
 	}
 
 	return devices, nil
 }
 
+// ListDevices calls ListDevicesContext with context.Background().
+func (a *AltaClient) ListDevices(siteName string) (Devices, error) {
+	return a.ListDevicesContext(context.Background(), siteName)
+}
+
 // TODO: Refactor this method
-func (a *AltaClient) EditDevice(device Device) error {
+func (a *AltaClient) EditDeviceContext(ctx context.Context, device Device) error {
 	siteURL := "client/edit"
 
 	// using the Device struct as a temporary request type
-	if err := a.postRequest(siteURL, device, nil); err != nil {
+	if err := a.postRequest(ctx, "devices.edit", siteURL, device, nil); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// EditDevice calls EditDeviceContext with context.Background().
+func (a *AltaClient) EditDevice(device Device) error {
+	return a.EditDeviceContext(context.Background(), device)
+}
+
 // TODO: Update devices
@@ -0,0 +1,114 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors used to classify an *APIError with errors.Is.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServerError  = errors.New("server error")
+)
+
+// APIError is returned when the API responds with a non-200 status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Header     http.Header
+	Body       []byte // Raw response body, truncated to the client's response size limit
+	Message    string // Message parsed from the body, if any
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: request failed with status code %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s %s: request failed with status code %d", e.Method, e.Path, e.StatusCode)
+}
+
+// Is reports whether the error matches one of the sentinel classifications.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		// The API reports expired or invalid tokens as a http-400 with API_Unauthorized in the body
+		return e.StatusCode == http.StatusUnauthorized ||
+			(e.StatusCode == http.StatusBadRequest && e.Message == API_Unauthorized)
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest && e.Message != API_Unauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServerError:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// newAPIError builds an APIError from a failed response and its body.
+func newAPIError(method, path string, resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		Method:     method,
+		Path:       path,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		Message:    parseErrorMessage(body),
+	}
+}
+
+// parseErrorMessage extracts a human-readable message from an error body. The API returns either a bare JSON
+// string (e.g. "Unauthorized"), an object with a message/error field, or plain text.
+func parseErrorMessage(body []byte) string {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" {
+		return ""
+	}
+
+	var msg string
+	if err := json.Unmarshal([]byte(trimmed), &msg); err == nil {
+		return msg
+	}
+
+	var obj struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(trimmed), &obj); err == nil {
+		if obj.Message != "" {
+			return obj.Message
+		}
+		if obj.Error != "" {
+			return obj.Error
+		}
+		return ""
+	}
+
+	return trimmed
+}
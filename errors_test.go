@@ -0,0 +1,86 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		message  string
+		sentinel error
+	}{
+		{"unauthorized body", http.StatusBadRequest, `"Unauthorized"`, "Unauthorized", ErrUnauthorized},
+		{"unauthorized status", http.StatusUnauthorized, ``, "", ErrUnauthorized},
+		{"bad request", http.StatusBadRequest, `{"message":"invalid site id"}`, "invalid site id", ErrBadRequest},
+		{"forbidden", http.StatusForbidden, `forbidden`, "forbidden", ErrForbidden},
+		{"not found", http.StatusNotFound, `{"error":"no such site"}`, "no such site", ErrNotFound},
+		{"rate limited", http.StatusTooManyRequests, ``, "", ErrRateLimited},
+		{"server error", http.StatusBadGateway, `<html>bad gateway</html>`, "<html>bad gateway</html>", ErrServerError},
+	}
+
+	sentinels := []error{ErrBadRequest, ErrUnauthorized, ErrForbidden, ErrNotFound, ErrRateLimited, ErrServerError}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiErr := &APIError{
+				Method:     http.MethodGet,
+				Path:       "site",
+				StatusCode: tt.status,
+				Body:       []byte(tt.body),
+				Message:    parseErrorMessage([]byte(tt.body)),
+			}
+
+			assert.Equal(t, tt.message, apiErr.Message)
+			for _, sentinel := range sentinels {
+				assert.Equal(t, sentinel == tt.sentinel, errors.Is(apiErr, sentinel), "sentinel: %v", sentinel)
+			}
+		})
+	}
+}
+
+func TestAPIErrorFromResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`"Unauthorized"`))
+	}))
+	defer server.Close()
+
+	testClient := newTestClient(server, nil)
+
+	_, err := testClient.GetSiteContext(context.Background(), "abc123")
+	require.Error(t, err)
+
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.MethodGet, apiErr.Method)
+	assert.Equal(t, "site", apiErr.Path)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, []byte(`"Unauthorized"`), apiErr.Body)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.NotErrorIs(t, err, ErrBadRequest)
+}
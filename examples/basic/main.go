@@ -1,20 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os"
 
 	"github.com/mikeee/altalabs-go"
 )
 
 func main() {
-	client, err := altalabs.NewAltaClient(os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"),
+	ctx := context.Background()
+
+	// Credentials come from SDK_ALTA_USER and SDK_ALTA_PASS, or ~/.config/altalabs/credentials
+	client, err := altalabs.NewAltaClientWithCredentials(ctx, altalabs.DefaultCredentialsChain(),
 		altalabs.WithAltaEndpoint(altalabs.API_BASE_URL))
 	if err != nil {
 		panic(err)
 	}
+	defer client.Close()
 
-	sites, err := client.ListSites()
+	sites, err := client.ListSitesContext(ctx)
 	if err != nil {
 		panic(err)
 	}
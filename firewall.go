@@ -15,7 +15,10 @@ limitations under the License.
 
 package altalabs
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 type Firewall struct {
 	Nat struct {
@@ -41,8 +44,8 @@ type Firewall struct {
 	} `json:"firewall"`
 }
 
-func (a *AltaClient) GetFirewall(siteID string) (*Firewall, error) {
-	site, err := a.GetSite(siteID)
+func (a *AltaClient) GetFirewallContext(ctx context.Context, siteID string) (*Firewall, error) {
+	site, err := a.GetSiteContext(ctx, siteID)
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +53,35 @@ func (a *AltaClient) GetFirewall(siteID string) (*Firewall, error) {
 	return &site.Firewall, nil
 }
 
+// GetFirewall calls GetFirewallContext with context.Background().
+func (a *AltaClient) GetFirewall(siteID string) (*Firewall, error) {
+	return a.GetFirewallContext(context.Background(), siteID)
+}
+
+func (a *AltaClient) UpdateFirewallContext(ctx context.Context) error {
+	return errors.New("not implemented")
+}
+
+// UpdateFirewall calls UpdateFirewallContext with context.Background().
 func (a *AltaClient) UpdateFirewall() error {
+	return a.UpdateFirewallContext(context.Background())
+}
+
+func (a *AltaClient) AddFirewallRuleContext(ctx context.Context) error {
 	return errors.New("not implemented")
 }
 
+// AddFirewallRule calls AddFirewallRuleContext with context.Background().
 func (a *AltaClient) AddFirewallRule() error {
-	return errors.New("not implemented")
+	return a.AddFirewallRuleContext(context.Background())
 }
 
-func (a *AltaClient) DeleteFirewall() error {
+func (a *AltaClient) DeleteFirewallContext(ctx context.Context) error {
 	// Clear/empty the firewall rules
 	return errors.New("not implemented")
 }
+
+// DeleteFirewall calls DeleteFirewallContext with context.Background().
+func (a *AltaClient) DeleteFirewall() error {
+	return a.DeleteFirewallContext(context.Background())
+}
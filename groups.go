@@ -15,7 +15,10 @@ limitations under the License.
 
 package altalabs
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type NewGroupRequest struct {
 	Name string `json:"name"`
@@ -35,40 +38,55 @@ type DeleteGroupRequest struct {
 	ID string `json:"id"`
 }
 
-func (a *AltaClient) AddGroup(name string) (*string, error) {
+func (a *AltaClient) AddGroupContext(ctx context.Context, name string) (*string, error) {
 	URL := "group/add"
 
 	var req = NewGroupRequest{Name: name}
 
 	var resp NewGroupResponse
 
-	if err := a.postRequest(URL, req, &resp); err != nil {
+	if err := a.postRequest(ctx, "groups.add", URL, req, &resp); err != nil {
 		return nil, fmt.Errorf("failed to add group: %w", err)
 	}
 
 	return &resp.ID, nil
 }
 
-func (a *AltaClient) EditGroup(req EditGroupRequest) error {
+// AddGroup calls AddGroupContext with context.Background().
+func (a *AltaClient) AddGroup(name string) (*string, error) {
+	return a.AddGroupContext(context.Background(), name)
+}
+
+func (a *AltaClient) EditGroupContext(ctx context.Context, req EditGroupRequest) error {
 	URL := "group/edit"
 
-	if err := a.postRequest(URL, req, nil); err != nil {
+	if err := a.postRequest(ctx, "groups.edit", URL, req, nil); err != nil {
 		return fmt.Errorf("failed to edit group: %w", err)
 	}
 
 	return nil
 }
 
-func (a *AltaClient) DeleteGroup(id string) error {
+// EditGroup calls EditGroupContext with context.Background().
+func (a *AltaClient) EditGroup(req EditGroupRequest) error {
+	return a.EditGroupContext(context.Background(), req)
+}
+
+func (a *AltaClient) DeleteGroupContext(ctx context.Context, id string) error {
 	URL := "group/delete"
 
 	req := DeleteGroupRequest{
 		ID: id,
 	}
 
-	if err := a.postRequest(URL, req, nil); err != nil {
+	if err := a.postRequest(ctx, "groups.delete", URL, req, nil); err != nil {
 		return fmt.Errorf("failed to delete group: %w", err)
 	}
 
 	return nil
 }
+
+// DeleteGroup calls DeleteGroupContext with context.Background().
+func (a *AltaClient) DeleteGroup(id string) error {
+	return a.DeleteGroupContext(context.Background(), id)
+}
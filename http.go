@@ -0,0 +1,127 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"net/http"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// WithHTTPClient sets the http.Client used for API, Cognito and MQTT connections. The client is copied, so later
+// changes to it have no effect. WithTransport and WithTimeout take precedence over the client's own settings.
+func WithHTTPClient(client *http.Client) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.HTTPClient = client
+	}
+}
+
+// WithTransport sets the http.RoundTripper used for API and Cognito requests, e.g. to route through a proxy or
+// trust a corporate CA. If it is an *http.Transport, its proxy and TLS settings also apply to MQTT connections.
+func WithTransport(transport http.RoundTripper) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.Transport = transport
+	}
+}
+
+// WithTimeout limits the time taken by each request, including reading the response body. It is also used as the
+// MQTT connect timeout.
+func WithTimeout(timeout time.Duration) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.Timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with API requests and MQTT connections.
+func WithUserAgent(userAgent string) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.UserAgent = userAgent
+	}
+}
+
+// httpClient builds the http.Client described by the options.
+func (options *altaClientOptions) httpClient() *http.Client {
+	client := &http.Client{}
+	if options.HTTPClient != nil {
+		*client = *options.HTTPClient
+	}
+
+	if options.Transport != nil {
+		client.Transport = options.Transport
+	}
+	if options.Timeout > 0 {
+		client.Timeout = options.Timeout
+	}
+	if options.UserAgent != "" {
+		client.Transport = &userAgentTransport{
+			userAgent: options.UserAgent,
+			next:      client.Transport,
+		}
+	}
+
+	return client
+}
+
+// userAgentTransport sets the User-Agent header on requests that don't already have one.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") != "" {
+		return t.transport().RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.transport().RoundTrip(req)
+}
+
+func (t *userAgentTransport) transport() http.RoundTripper {
+	if t.next != nil {
+		return t.next
+	}
+	return http.DefaultTransport
+}
+
+// applyMqttOptions carries the http.Client settings over to the MQTT websocket dialer.
+func applyMqttOptions(client *http.Client, opts *mqtt.ClientOptions) {
+	if client == nil {
+		return
+	}
+
+	if client.Timeout > 0 {
+		opts.SetConnectTimeout(client.Timeout)
+	}
+
+	transport := client.Transport
+	if uaTransport, ok := transport.(*userAgentTransport); ok {
+		opts.SetHTTPHeaders(http.Header{"User-Agent": []string{uaTransport.userAgent}})
+		transport = uaTransport.next
+	}
+
+	if httpTransport, ok := transport.(*http.Transport); ok {
+		if httpTransport.TLSClientConfig != nil {
+			opts.SetTLSConfig(httpTransport.TLSClientConfig)
+		}
+		if httpTransport.Proxy != nil {
+			opts.SetWebsocketOptions(&mqtt.WebsocketOptions{Proxy: httpTransport.Proxy})
+		}
+	}
+}
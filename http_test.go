@@ -0,0 +1,126 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransport records the hosts of requests and rejects them without touching the network.
+type recordingTransport struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hosts = append(t.hosts, req.URL.Host)
+
+	// A Cognito error that the AWS SDK will not retry
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(`{"__type":"NotAuthorizedException","message":"recording transport"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestHTTPClientOptions(t *testing.T) {
+	t.Run("Defaults to an empty client", func(t *testing.T) {
+		client := loadAltaClientOptions().httpClient()
+		assert.Equal(t, &http.Client{}, client)
+	})
+
+	t.Run("Provided client should be copied and overridden", func(t *testing.T) {
+		provided := &http.Client{Timeout: time.Minute}
+		transport := &recordingTransport{}
+
+		client := loadAltaClientOptions(
+			WithHTTPClient(provided),
+			WithTransport(transport),
+			WithTimeout(time.Second),
+		).httpClient()
+
+		assert.Equal(t, time.Second, client.Timeout)
+		assert.Equal(t, transport, client.Transport)
+		assert.Equal(t, time.Minute, provided.Timeout)
+		assert.Nil(t, provided.Transport)
+	})
+
+	t.Run("User-Agent should be sent", func(t *testing.T) {
+		var userAgent string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgent = r.Header.Get("User-Agent")
+		}))
+		defer server.Close()
+
+		client := loadAltaClientOptions(WithUserAgent("altalabs-test/1.0")).httpClient()
+		resp, err := client.Get(server.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "altalabs-test/1.0", userAgent)
+	})
+
+	t.Run("Cognito requests should use the provided client", func(t *testing.T) {
+		transport := &recordingTransport{}
+		httpClient := loadAltaClientOptions(WithTransport(transport)).httpClient()
+
+		authClient, err := NewAuthClient(COGNITO_REGION, WithAuthHTTPClient(httpClient))
+		require.NoError(t, err)
+
+		err = authClient.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password"))
+		require.Error(t, err)
+		require.NotEmpty(t, transport.hosts)
+		assert.Contains(t, transport.hosts[0], "cognito-idp")
+	})
+
+	t.Run("MQTT should inherit the transport settings", func(t *testing.T) {
+		tlsConfig := &tls.Config{ServerName: "example.com"}
+		proxyURL, err := url.Parse("http://proxy.example.com:3128")
+		require.NoError(t, err)
+
+		client := loadAltaClientOptions(
+			WithTransport(&http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyURL(proxyURL)}),
+			WithTimeout(3*time.Second),
+			WithUserAgent("altalabs-test/1.0"),
+		).httpClient()
+
+		opts := mqtt.NewClientOptions()
+		applyMqttOptions(client, opts)
+
+		assert.Equal(t, 3*time.Second, opts.ConnectTimeout)
+		assert.Equal(t, tlsConfig, opts.TLSConfig)
+		assert.Equal(t, "altalabs-test/1.0", opts.HTTPHeaders.Get("User-Agent"))
+		require.NotNil(t, opts.WebsocketOptions)
+		proxied, err := opts.WebsocketOptions.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "manage.alta.inc"}})
+		require.NoError(t, err)
+		assert.Equal(t, proxyURL, proxied)
+	})
+}
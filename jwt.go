@@ -0,0 +1,80 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotSignedIn is returned when the session is inspected before signing in.
+var ErrNotSignedIn = errors.New("not signed in")
+
+// Claims identify the signed in user, decoded from a Cognito ID token.
+type Claims struct {
+	Subject   string    // sub, the user's immutable ID
+	Email     string    // email
+	Username  string    // cognito:username
+	IssuedAt  time.Time // iat
+	ExpiresAt time.Time // exp
+}
+
+// ParseClaims decodes the claims of a Cognito ID token. The signature is not verified, the token is only ever sent
+// back to the API that issued it.
+func ParseClaims(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token: expected 3 parts")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+
+	var raw struct {
+		Subject   string `json:"sub"`
+		Email     string `json:"email"`
+		Username  string `json:"cognito:username"`
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+
+	claims := &Claims{Subject: raw.Subject, Email: raw.Email, Username: raw.Username}
+	if raw.IssuedAt != 0 {
+		claims.IssuedAt = time.Unix(raw.IssuedAt, 0)
+	}
+	if raw.ExpiresAt != 0 {
+		claims.ExpiresAt = time.Unix(raw.ExpiresAt, 0)
+	}
+	return claims, nil
+}
+
+// Claims returns the claims of the current ID token.
+func (auth *AuthClient) Claims() (*Claims, error) {
+	token := auth.GetIDToken()
+	if token == "" {
+		return nil, ErrNotSignedIn
+	}
+	return ParseClaims(token)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClaims(t *testing.T) {
+	t.Run("Claims should be decoded from the payload", func(t *testing.T) {
+		claims, err := ParseClaims(newTestJWT(map[string]any{
+			"sub":              "0f1e2d3c",
+			"email":            "user@example.com",
+			"cognito:username": "user",
+			"iat":              1700000000,
+			"exp":              4102444800, // Past 2038
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, &Claims{
+			Subject:   "0f1e2d3c",
+			Email:     "user@example.com",
+			Username:  "user",
+			IssuedAt:  time.Unix(1700000000, 0),
+			ExpiresAt: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC).Local(),
+		}, claims)
+	})
+
+	t.Run("Missing times should be zero", func(t *testing.T) {
+		claims, err := ParseClaims(newTestJWT(map[string]any{"sub": "0f1e2d3c"}))
+		require.NoError(t, err)
+		assert.True(t, claims.IssuedAt.IsZero())
+		assert.True(t, claims.ExpiresAt.IsZero())
+	})
+
+	t.Run("Malformed tokens should error", func(t *testing.T) {
+		for _, token := range []string{"", "abc", "a.!!!.c", "a.bm90IGpzb24.c"} {
+			_, err := ParseClaims(token)
+			assert.Error(t, err, token)
+		}
+	})
+}
+
+func TestSessionClaims(t *testing.T) {
+	t.Run("Claims should error before signing in", func(t *testing.T) {
+		_, err := (&AuthClient{}).Claims()
+		require.ErrorIs(t, err, ErrNotSignedIn)
+	})
+
+	t.Run("Claims should identify the signed in user", func(t *testing.T) {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.AddUser("user@example.com", "correct horse")
+
+		auth, err := NewAuthClient("", cognito.authOptions()...)
+		require.NoError(t, err)
+		require.NoError(t, auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("user@example.com", "correct horse")))
+
+		claims, err := auth.Claims()
+		require.NoError(t, err)
+		assert.Equal(t, "sub-user@example.com", claims.Subject)
+		assert.Equal(t, "user@example.com", claims.Email)
+		assert.Equal(t, "user@example.com", claims.Username)
+		assert.Equal(t, claims.ExpiresAt, auth.GetExpiryTime())
+	})
+
+	t.Run("The exp claim should take precedence over ExpiresIn", func(t *testing.T) {
+		exp := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+		auth := &AuthClient{}
+		auth.setAuth(NewConfig(), &types.AuthenticationResultType{
+			IdToken:   aws.String(newTestJWT(map[string]any{"exp": exp.Unix()})),
+			ExpiresIn: 3600,
+		})
+		assert.True(t, exp.Equal(auth.GetExpiryTime()))
+
+		auth.setAuth(NewConfig(), &types.AuthenticationResultType{IdToken: aws.String("opaque"), ExpiresIn: 3600})
+		assert.WithinDuration(t, time.Now().Add(time.Hour), auth.GetExpiryTime(), 2*time.Second)
+	})
+}
+
+func TestExpirySkew(t *testing.T) {
+	expiry := time.Now().Add(time.Minute)
+
+	auth, err := NewAuthClient("")
+	require.NoError(t, err)
+	assert.NoError(t, auth.checkExpiry(expiry), "the default skew should accept a token a minute from expiry")
+	assert.ErrorIs(t, auth.checkExpiry(time.Now().Add(DefaultExpirySkew/2)), ErrorAuthExpired)
+
+	auth, err = NewAuthClient("", WithExpirySkew(2*time.Minute))
+	require.NoError(t, err)
+	assert.ErrorIs(t, auth.checkExpiry(expiry), ErrorAuthExpired, "the token should expire within the skew")
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// redactedFields are JSON fields holding credentials or secrets, matched case-insensitively at any depth. They
+// cover the body token, Site.Password, Site.Meshpw, Site.Community, Site.Iappkey and the SSID passwords,
+// RADIUS/hotspot secrets and fast transition key.
+var redactedFields = map[string]bool{
+	"token":           true,
+	"password":        true,
+	"meshpw":          true,
+	"community":       true,
+	"iappkey":         true,
+	"radiussecret":    true,
+	"hotspotsecret":   true,
+	"hotspotpassword": true,
+	"ftkey":           true,
+}
+
+// redactedHeaders are headers carrying the ID token or other credentials.
+var redactedHeaders = map[string]bool{
+	"Token":         true,
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+// WithLogger sets the logger used by the client. Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.Logger = logger
+	}
+}
+
+// WithDebug logs every request and response at debug level, with credentials and secrets redacted. The logger
+// must have debug level enabled for anything to be written.
+func WithDebug(debug bool) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.Debug = debug
+	}
+}
+
+// WithAuthLogger sets the logger the AuthClient warns through when the token store fails. Defaults to
+// slog.Default().
+func WithAuthLogger(logger *slog.Logger) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.Logger = logger
+	}
+}
+
+// log returns the client's logger.
+func (a *AltaClient) log() *slog.Logger {
+	if a.logger != nil {
+		return a.logger
+	}
+	return slog.Default()
+}
+
+// log returns the auth client's logger.
+func (auth *AuthClient) log() *slog.Logger {
+	if auth.logger != nil {
+		return auth.logger
+	}
+	return slog.Default()
+}
+
+// debugMiddleware logs the request as sent on the wire and the response received.
+func (a *AltaClient) debugMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (*http.Response, error) {
+		logger := a.log()
+		if !logger.Enabled(ctx, slog.LevelDebug) {
+			return next(ctx, req)
+		}
+
+		logger.DebugContext(ctx, "Sending request",
+			slog.String("method", req.Method),
+			slog.String("path", req.Path),
+			slog.String("query", req.RawQuery),
+			slog.Any("header", redactHeader(req.Header)),
+			slog.String("body", string(redactJSON(req.Body))))
+
+		start := time.Now()
+		resp, err := next(ctx, req)
+		if err != nil {
+			logger.DebugContext(ctx, "Request failed",
+				slog.String("method", req.Method),
+				slog.String("path", req.Path),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("error", err.Error()))
+			return resp, err
+		}
+
+		// Buffer the start of the body so it can be logged and still be read in full by the caller
+		body, readErr := io.ReadAll(io.LimitReader(resp.Body, a.maxResponseBytes()))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+
+		attrs := []any{
+			slog.String("method", req.Method),
+			slog.String("path", req.Path),
+			slog.Int("status", resp.StatusCode),
+			slog.Duration("duration", time.Since(start)),
+			slog.Any("header", redactHeader(resp.Header)),
+			slog.String("body", string(redactJSON(body))),
+		}
+		if readErr != nil {
+			attrs = append(attrs, slog.String("error", readErr.Error()))
+		}
+		logger.DebugContext(ctx, "Received response", attrs...)
+
+		return resp, nil
+	}
+}
+
+// redactHeader returns a copy of the header with credentials replaced.
+func redactHeader(header http.Header) http.Header {
+	clone := header.Clone()
+	for key := range clone {
+		if redactedHeaders[http.CanonicalHeaderKey(key)] {
+			clone[key] = []string{redacted}
+		}
+	}
+	return clone
+}
+
+// redactJSON returns the JSON body with the values of redactedFields replaced. Bodies that aren't valid JSON are
+// replaced entirely as they can't be redacted reliably.
+func redactJSON(body []byte) []byte {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return []byte(redacted)
+	}
+
+	out, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return []byte(redacted)
+	}
+	return out
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if redactedFields[strings.ToLower(key)] {
+				if s, ok := field.(string); ok && s == "" {
+					continue // Keep empty values, whether a secret is set is useful when debugging
+				}
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(field)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// redactedLogValue is used to implement slog.LogValuer on types holding secrets.
+func redactedLogValue(v any) slog.Value {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return slog.StringValue(redacted)
+	}
+
+	var value any
+	if err := json.Unmarshal(redactJSON(body), &value); err != nil {
+		return slog.StringValue(redacted)
+	}
+	return slog.AnyValue(value)
+}
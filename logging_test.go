@@ -0,0 +1,141 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugLogging(t *testing.T) {
+	testIDToken := "eyJ.secret-id-token.sig"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/site":
+			_, _ = w.Write([]byte(`{"id":"site","password":"site-password","meshpw":"mesh-password","community":"snmp-community","iappkey":"iapp-key"}`))
+		default:
+			_, _ = w.Write([]byte(`{"id":"ssid","ftkey":"ft-key"}`))
+		}
+	}))
+	defer server.Close()
+
+	secrets := []string{
+		testIDToken, "site-password", "mesh-password", "snmp-community", "iapp-key",
+		"wifi-password", "radius-secret", "hotspot-secret", "ft-key",
+	}
+
+	t.Run("Requests and responses should be logged with secrets redacted", func(t *testing.T) {
+		var buf bytes.Buffer
+		client := newTestClient(server, newSignedInAuthClient(nil, testIDToken, time.Now().Add(time.Minute)),
+			WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+			WithDebug(true),
+		)
+
+		site, err := client.GetSiteContext(context.Background(), "site")
+		require.NoError(t, err)
+		assert.Equal(t, "site-password", site.Password)
+
+		var req NewSSIDRequest
+		req.Config.Ssid = "ssid"
+		req.Config.RadiusSecret = "radius-secret"
+		req.Config.HotspotSecret = "hotspot-secret"
+		req.Config.Passwords = append(req.Config.Passwords, struct {
+			Network       string `json:"network"`
+			Password      string `json:"password"`
+			Vlan          int    `json:"vlan"`
+			DlRate        int    `json:"dlRate"`
+			UlRate        int    `json:"ulRate"`
+			IgnoreHotspot bool   `json:"ignoreHotspot"`
+			IgnoreSched   bool   `json:"ignoreSched"`
+			IgnoreFilter  bool   `json:"ignoreFilter"`
+			Locked        bool   `json:"locked"`
+		}{Network: "lan", Password: "wifi-password"})
+
+		_, err = client.AddSSIDContext(context.Background(), req)
+		require.NoError(t, err)
+
+		logs := buf.String()
+		assert.Contains(t, logs, "Sending request")
+		assert.Contains(t, logs, "Received response")
+		assert.Contains(t, logs, `"path":"wifi/ssid"`)
+		assert.Contains(t, logs, redacted)
+		for _, secret := range secrets {
+			assert.NotContains(t, logs, secret)
+		}
+	})
+
+	t.Run("Requests should not be logged unless debug is enabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		client := newTestClient(server, newSignedInAuthClient(nil, testIDToken, time.Now().Add(time.Minute)),
+			WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+			WithDebug(false),
+		)
+
+		_, err := client.GetSiteContext(context.Background(), "site")
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("Logged types should redact their secrets", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+		site := Site{ID: "site", Password: "site-password", Meshpw: "mesh-password", Community: "snmp-community", Iappkey: "iapp-key"}
+		var ssid SSID
+		ssid.Config.RadiusSecret = "radius-secret"
+		ssid.Config.HotspotSecret = "hotspot-secret"
+		ssid.Ftkey = "ft-key"
+
+		logger.Info("resources", slog.Any("site", site), slog.Any("ssid", ssid))
+
+		logs := buf.String()
+		assert.Contains(t, logs, `"id":"site"`)
+		for _, secret := range secrets {
+			assert.NotContains(t, logs, secret)
+		}
+	})
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"empty", ``, ``},
+		{"token", `{"name":"group","token":"abc"}`, `{"name":"group","token":"[REDACTED]"}`},
+		{"nested", `{"config":{"passwords":[{"password":"abc","network":"lan"}]}}`, `{"config":{"passwords":[{"network":"lan","password":"[REDACTED]"}]}}`},
+		{"empty secrets are kept", `{"password":""}`, `{"password":""}`},
+		{"numbers are preserved", `{"port":1812,"radiusSecret":"abc"}`, `{"port":1812,"radiusSecret":"[REDACTED]"}`},
+		{"fast transition key", `{"ftkey":"abc"}`, `{"ftkey":"[REDACTED]"}`},
+		{"invalid json", `token=abc`, redacted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, string(redactJSON([]byte(tt.body))))
+		})
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mikeee/altalabs-go/util"
+)
+
+// Request is a single REST call as seen by middleware.
+type Request struct {
+	Operation string // Logical API operation, e.g. "sites.list" or "wifi.ssid.add"
+	Method    string
+	Path      string      // Endpoint path relative to the client's Endpoint, e.g. "sites/list"
+	RawQuery  string      // Encoded query parameters for GET requests
+	Payload   any         // The GET params or POST payload before encoding, e.g. NewSSIDRequest
+	Header    http.Header // Headers sent with the request
+	Body      []byte      // The encoded POST payload, nil for GET requests
+}
+
+// clone returns a copy of the request that can be modified without affecting the original.
+func (r *Request) clone() *Request {
+	clone := *r
+	clone.Header = r.Header.Clone()
+	if clone.Header == nil {
+		clone.Header = http.Header{}
+	}
+	if r.Body != nil {
+		clone.Body = append([]byte(nil), r.Body...)
+	}
+	return &clone
+}
+
+// Handler sends a Request and returns the API's response. A response with a non-200 status code is not an error
+// at this stage, it is converted to an *APIError once it has passed back through the middleware.
+type Handler func(ctx context.Context, req *Request) (*http.Response, error)
+
+// Middleware wraps a Handler with cross-cutting behaviour such as tracing, metrics or auditing.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware adds middleware around every REST call. Middleware is applied in the order given, the first
+// wraps all others. It runs for every attempt, including retries, and sees the request before the token is added.
+func WithMiddleware(middleware ...Middleware) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.Middleware = append(options.Middleware, middleware...)
+	}
+}
+
+// handler builds the middleware chain: user middleware, then the token middleware, then the debug logging
+// middleware if enabled, then the transport.
+func (a *AltaClient) handler() Handler {
+	handler := Handler(a.transport)
+	if a.debug {
+		handler = a.debugMiddleware(handler)
+	}
+	handler = a.tokenMiddleware(handler)
+	for i := len(a.middleware) - 1; i >= 0; i-- {
+		handler = a.middleware[i](handler)
+	}
+	return handler
+}
+
+// transport is the innermost handler, sending the request over HTTP.
+func (a *AltaClient) transport(ctx context.Context, req *Request) (*http.Response, error) {
+	httpReq, err := a.request(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// sentTokenKey is the context key of a *string in which tokenMiddleware records the token it sent.
+type sentTokenKey struct{}
+
+// tokenMiddleware renews the ID token if it is close to expiring, sharing the refresh with any other requests
+// waiting on it, and adds it to the request. The API expects the token in the Token header of GET requests and as a
+// "token" field in the body of POST requests.
+func (a *AltaClient) tokenMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req *Request) (*http.Response, error) {
+		token, expiry := a.AuthClient.token()
+		if err := a.AuthClient.checkExpiry(expiry); err != nil {
+			a.log().InfoContext(ctx, "Refreshing auth token", slog.String("error", err.Error()))
+			if err := a.AuthClient.refresh(ctx, token); err != nil {
+				// Sending a token that has already expired is pointless if it can't be renewed
+				if errors.Is(err, ErrReauthRequired) && !time.Now().Before(expiry) {
+					return nil, err
+				}
+				a.log().ErrorContext(ctx, "Failed to refresh auth token", slog.String("error", err.Error()))
+			} else {
+				a.log().InfoContext(ctx, "Refreshed auth token")
+			}
+			token = a.AuthClient.GetIDToken()
+		}
+		if sent, ok := ctx.Value(sentTokenKey{}).(*string); ok {
+			*sent = token
+		}
+
+		if req.Body != nil {
+			body, err := util.AddTokenToJSONBody(req.Body, token)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add token to json body: %w", err)
+			}
+			req.Body = body
+		}
+
+		if req.Method == http.MethodGet {
+			req.Header.Set("Token", token)
+		}
+
+		return next(ctx, req)
+	}
+}
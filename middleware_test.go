@@ -0,0 +1,135 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	testIDToken := "id_token"
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		switch r.URL.Path {
+		case "/wifi/ssid/delete":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/sites/list":
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"group_id"}`))
+	}))
+	defer server.Close()
+
+	type call struct {
+		name    string
+		method  string
+		path    string
+		payload any
+		body    string
+		status  int
+	}
+
+	recorder := func(name string, calls *[]call) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*http.Response, error) {
+				resp, err := next(ctx, req)
+				c := call{name: name, method: req.Method, path: req.Path, payload: req.Payload}
+				if resp != nil {
+					c.status = resp.StatusCode
+				}
+				*calls = append(*calls, c)
+				return resp, err
+			}
+		}
+	}
+
+	t.Run("Middleware should see the path, payload and status", func(t *testing.T) {
+		var calls []call
+		client := newTestClient(server, nil, WithMiddleware(recorder("outer", &calls), recorder("inner", &calls)))
+
+		_, err := client.AddGroupContext(context.Background(), "group")
+		require.NoError(t, err)
+
+		require.Len(t, calls, 2)
+		assert.Equal(t, "inner", calls[0].name)
+		assert.Equal(t, "outer", calls[1].name)
+		assert.Equal(t, http.MethodPost, calls[1].method)
+		assert.Equal(t, "group/add", calls[1].path)
+		assert.IsType(t, NewGroupRequest{}, calls[1].payload)
+		assert.Equal(t, http.StatusOK, calls[1].status)
+	})
+
+	t.Run("Middleware should see failed responses", func(t *testing.T) {
+		var calls []call
+		client := newTestClient(server, nil, WithMiddleware(recorder("audit", &calls)))
+
+		err := client.DeleteSSIDContext(context.Background(), "ssid")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		require.Len(t, calls, 1)
+		assert.Equal(t, "wifi/ssid/delete", calls[0].path)
+		assert.Equal(t, http.StatusNotFound, calls[0].status)
+	})
+
+	t.Run("Middleware should see the body before the token is added", func(t *testing.T) {
+		var body string
+		client := newTestClient(server, nil, WithMiddleware(func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*http.Response, error) {
+				body = string(req.Body)
+				return next(ctx, req)
+			}
+		}))
+
+		_, err := client.AddGroupContext(context.Background(), "group")
+		require.NoError(t, err)
+		assert.Equal(t, `{"name":"group"}`, body)
+	})
+
+	t.Run("Headers set by middleware should be sent", func(t *testing.T) {
+		client := newTestClient(server, nil, WithMiddleware(func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*http.Response, error) {
+				req.Header.Set("X-Request-Id", "request-id")
+				return next(ctx, req)
+			}
+		}))
+
+		_, err := client.ListSitesContext(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "request-id", received.Get("X-Request-Id"))
+		assert.Equal(t, testIDToken, received.Get("Token"))
+	})
+
+	t.Run("Middleware returning no response should fail the request", func(t *testing.T) {
+		client := newTestClient(server, nil, WithMiddleware(func(next Handler) Handler {
+			return func(ctx context.Context, req *Request) (*http.Response, error) {
+				return nil, nil
+			}
+		}))
+
+		_, err := client.ListSitesContext(context.Background())
+		require.EqualError(t, err, "middleware returned no response")
+	})
+}
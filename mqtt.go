@@ -16,14 +16,32 @@ limitations under the License.
 package altalabs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"os"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"time"
 )
 
-func (a *AltaClient) MqttConn() error {
-	testSite := os.Getenv("SDK_ALTA_SITE")
+func (a *AltaClient) MqttConnContext(ctx context.Context) (err error) {
+	ctx, span := a.tel().tracer.Start(ctx, "mqtt.connect", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if a.closed.Load() {
+		return ErrClientClosed
+	}
+
+	if a.defaultSite == "" {
+		return errors.New("no site to connect to, see WithDefaultSite")
+	}
 
 	token := a.AuthClient.GetIDToken()
 	if token == "" {
@@ -33,7 +51,7 @@ func (a *AltaClient) MqttConn() error {
 	fullurl := "wss://manage.alta.inc/mqtt?x-amz-customauthorizer-name=DeviceAuth-prod"
 	fullurl += fmt.Sprintf("&token=%s", token)
 
-	fullurl += fmt.Sprintf("&site=%s", testSite)
+	fullurl += fmt.Sprintf("&site=%s", a.defaultSite)
 
 	fullurl += "&fe=web"
 
@@ -49,14 +67,29 @@ func (a *AltaClient) MqttConn() error {
 		SetPingTimeout(5 * time.
 			Second)
 
+	applyMqttOptions(a.client, opts)
+
 	c := mqtt.NewClient(opts)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+	connectToken := c.Connect()
+	select {
+	case <-connectToken.Done():
+	case <-ctx.Done():
+		// Stop the connection attempt, otherwise the client keeps dialling in the background
+		c.Disconnect(0)
+		return ctx.Err()
+	}
+	if err := connectToken.Error(); err != nil {
+		return fmt.Errorf("failed to connect to mqtt: %w", err)
 	}
 
 	defer c.Disconnect(1)
 
-	fmt.Println("Connected to mqtt")
+	a.log().DebugContext(ctx, "Connected to mqtt")
 
 	return nil
 }
+
+// MqttConn calls MqttConnContext with context.Background().
+func (a *AltaClient) MqttConn() error {
+	return a.MqttConnContext(context.Background())
+}
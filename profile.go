@@ -0,0 +1,297 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	EnvConfigFile = "SDK_ALTA_CONFIG_FILE" // Overrides the default config file path
+
+	CredentialsSourceEnv    = "env"    // SDK_ALTA_USER and SDK_ALTA_PASS, see EnvCredentialsProvider
+	CredentialsSourceFile   = "file"   // A profile in the credentials file, see FileCredentialsProvider
+	CredentialsSourceStatic = "static" // username, password and refresh_token in the profile itself
+)
+
+// ErrProfileNotFound is returned when the config file has no profile with the requested name.
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Profile is a named set of client settings from the config file, ~/.config/altalabs/config.toml:
+//
+//	# Used when no profile is named and SDK_ALTA_PROFILE is unset
+//	default_profile = "production"
+//
+//	[profiles.production]
+//	credentials = "file"           # env, file or static, defaults to trying env then file
+//	site = "5f6c2a..."
+//	timeout = "30s"
+//	token_cache = "~/.cache/altalabs/production.json"
+//
+//	[profiles.lab]
+//	credentials = "static"
+//	username = "lab@example.com"
+//	password = "secret"
+//	endpoint = "https://lab.example.com/api/"
+type Profile struct {
+	Name string
+
+	Credentials        string // Credentials source, defaults to the environment then the credentials file
+	Username           string // Used by the static source
+	Password           string // Used by the static source
+	RefreshToken       string // Used by the static source, in place of the password
+	CredentialsFile    string // Used by the file source, defaults to ~/.config/altalabs/credentials
+	CredentialsProfile string // Used by the file source, defaults to the profile name
+
+	Endpoint        string        // Defaults to API_BASE_URL
+	CognitoEndpoint string        // Defaults to the regional Cognito endpoint
+	UserPoolID      string        // Defaults to the Alta Labs user pool
+	ClientID        string        // Defaults to ALTA_CLIENT_ID
+	Site            string        // Site ID used when none is given, e.g. by MqttConn
+	Timeout         time.Duration // Defaults to no timeout
+	TokenCache      string        // Path of a FileTokenStore for sessions, kept on Close, defaults to signing in every time
+}
+
+// configFile is the layout of the config file. Profiles are decoded loosely so that parseProfile can reject
+// mistakes in the selected profile only.
+type configFile struct {
+	DefaultProfile string                    `toml:"default_profile"`
+	Profiles       map[string]map[string]any `toml:"profiles"`
+}
+
+// DefaultConfigFile returns the default path of the config file, ~/.config/altalabs/config.toml.
+func DefaultConfigFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "altalabs", "config.toml"), nil
+}
+
+// LoadProfile reads a profile from the config file at SDK_ALTA_CONFIG_FILE, or the default path. An empty name
+// selects SDK_ALTA_PROFILE, then the file's default_profile, then "default".
+func LoadProfile(name string) (*Profile, error) {
+	path := os.Getenv(EnvConfigFile)
+	if path == "" {
+		var err error
+		if path, err = DefaultConfigFile(); err != nil {
+			return nil, err
+		}
+	}
+	return LoadProfileFile(path, name)
+}
+
+// LoadProfileFile reads a profile from the config file at path, selecting it as LoadProfile does.
+func LoadProfileFile(path, name string) (*Profile, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrProfileNotFound, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	var config configFile
+	if _, err := toml.NewDecoder(file).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	if name == "" {
+		name = os.Getenv(EnvProfile)
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	values, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q in %s", ErrProfileNotFound, name, path)
+	}
+
+	profile, err := parseProfile(name, values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %q in %s: %w", name, path, err)
+	}
+	return profile, nil
+}
+
+// parseProfile maps the keys of a profile table onto a Profile, rejecting unknown keys so typos aren't ignored.
+func parseProfile(name string, values map[string]any) (*Profile, error) {
+	profile := &Profile{Name: name}
+
+	strs := map[string]*string{
+		"credentials":         &profile.Credentials,
+		"username":            &profile.Username,
+		"password":            &profile.Password,
+		"refresh_token":       &profile.RefreshToken,
+		"credentials_file":    &profile.CredentialsFile,
+		"credentials_profile": &profile.CredentialsProfile,
+		"endpoint":            &profile.Endpoint,
+		"cognito_endpoint":    &profile.CognitoEndpoint,
+		"user_pool_id":        &profile.UserPoolID,
+		"client_id":           &profile.ClientID,
+		"site":                &profile.Site,
+		"token_cache":         &profile.TokenCache,
+	}
+
+	for key, value := range values {
+		if key == "timeout" {
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return nil, err
+			}
+			profile.Timeout = timeout
+			continue
+		}
+
+		dest, ok := strs[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string", key)
+		}
+		*dest = str
+	}
+
+	switch profile.Credentials {
+	case "", CredentialsSourceEnv, CredentialsSourceFile, CredentialsSourceStatic:
+	default:
+		return nil, fmt.Errorf("unknown credentials source %q", profile.Credentials)
+	}
+
+	var err error
+	if profile.CredentialsFile, err = expandHome(profile.CredentialsFile); err != nil {
+		return nil, err
+	}
+	if profile.TokenCache, err = expandHome(profile.TokenCache); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// parseTimeout accepts a duration string such as "30s", or a number of seconds.
+func parseTimeout(value any) (time.Duration, error) {
+	switch value := value.(type) {
+	case string:
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout: %w", err)
+		}
+		return timeout, nil
+	case int64:
+		return time.Duration(value) * time.Second, nil
+	default:
+		return 0, errors.New("timeout must be a duration string or a number of seconds")
+	}
+}
+
+// expandHome replaces a leading ~ with the user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// CredentialsProvider returns the provider for the profile's credentials source.
+func (p *Profile) CredentialsProvider() CredentialsProvider {
+	switch p.Credentials {
+	case CredentialsSourceEnv:
+		return EnvCredentialsProvider{}
+	case CredentialsSourceFile:
+		return p.fileCredentialsProvider()
+	case CredentialsSourceStatic:
+		return StaticCredentialsProvider{Credentials: Credentials{
+			Username:     p.Username,
+			Password:     p.Password,
+			RefreshToken: p.RefreshToken,
+		}}
+	default:
+		return NewChainCredentialsProvider(EnvCredentialsProvider{}, p.fileCredentialsProvider())
+	}
+}
+
+func (p *Profile) fileCredentialsProvider() FileCredentialsProvider {
+	profile := p.CredentialsProfile
+	if profile == "" {
+		profile = p.Name
+	}
+	return FileCredentialsProvider{Path: p.CredentialsFile, Profile: profile}
+}
+
+// Options returns the client options for the profile's settings.
+func (p *Profile) Options() []newAltaClientOptions {
+	var opts []newAltaClientOptions
+	var authOpts []newAuthClientOptions
+
+	if p.Endpoint != "" {
+		opts = append(opts, WithAltaEndpoint(p.Endpoint))
+	}
+	if p.Site != "" {
+		opts = append(opts, WithDefaultSite(p.Site))
+	}
+	if p.Timeout > 0 {
+		opts = append(opts, WithTimeout(p.Timeout))
+	}
+	if p.TokenCache != "" {
+		opts = append(opts, WithTokenStore(NewFileTokenStore(p.TokenCache)))
+	}
+
+	if p.CognitoEndpoint != "" {
+		authOpts = append(authOpts, WithCognitoEndpoint(p.CognitoEndpoint))
+	}
+	if p.UserPoolID != "" {
+		authOpts = append(authOpts, WithUserPoolID(p.UserPoolID))
+	}
+	if p.ClientID != "" {
+		authOpts = append(authOpts, WithClientID(p.ClientID))
+	}
+	if len(authOpts) > 0 {
+		opts = append(opts, WithAuthOptions(authOpts...))
+	}
+
+	return opts
+}
+
+// NewAltaClientFromProfile creates a client from a profile in the config file, see LoadProfile. Options given
+// here are applied after the profile's, so they take precedence.
+func NewAltaClientFromProfile(ctx context.Context, name string, opts ...newAltaClientOptions) (*AltaClient, error) {
+	profile, err := LoadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewAltaClientWithCredentials(ctx, profile.CredentialsProvider(), append(profile.Options(), opts...)...)
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFile = `
+# Comments and blank lines are ignored
+default_profile = "production"
+
+[profiles.production]
+credentials = "file"
+site = "site-production" # trailing comments too
+timeout = "30s"
+token_cache = "~/.cache/altalabs/production.json"
+
+[profiles.lab]
+credentials = 'static'
+username = "lab@example.com"
+password = "p@ss \"word\" # not a comment"
+endpoint = "https://lab.example.com/api/"
+cognito_endpoint = "https://cognito.lab.example.com"
+user_pool_id = "eu-west-2_LabPool"
+client_id = "lab-client"
+timeout = 5
+
+[profiles.typo]
+usrname = "user"
+`
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+	clearCredentialsEnv(t)
+	t.Setenv(EnvConfigFile, "")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	path := writeConfigFile(t, testConfigFile)
+
+	t.Run("Should select the default profile", func(t *testing.T) {
+		profile, err := LoadProfileFile(path, "")
+		require.NoError(t, err)
+		assert.Equal(t, &Profile{
+			Name:        "production",
+			Credentials: CredentialsSourceFile,
+			Site:        "site-production",
+			Timeout:     30 * time.Second,
+			TokenCache:  filepath.Join(home, ".cache", "altalabs", "production.json"),
+		}, profile)
+	})
+
+	t.Run("Should select the named profile", func(t *testing.T) {
+		profile, err := LoadProfileFile(path, "lab")
+		require.NoError(t, err)
+		assert.Equal(t, &Profile{
+			Name:            "lab",
+			Credentials:     CredentialsSourceStatic,
+			Username:        "lab@example.com",
+			Password:        `p@ss "word" # not a comment`,
+			Endpoint:        "https://lab.example.com/api/",
+			CognitoEndpoint: "https://cognito.lab.example.com",
+			UserPoolID:      "eu-west-2_LabPool",
+			ClientID:        "lab-client",
+			Timeout:         5 * time.Second,
+		}, profile)
+	})
+
+	t.Run("SDK_ALTA_PROFILE should take precedence over default_profile", func(t *testing.T) {
+		t.Setenv(EnvProfile, "lab")
+		profile, err := LoadProfileFile(path, "")
+		require.NoError(t, err)
+		assert.Equal(t, "lab", profile.Name)
+	})
+
+	t.Run("SDK_ALTA_CONFIG_FILE should override the default path", func(t *testing.T) {
+		_, err := LoadProfile("lab")
+		require.ErrorIs(t, err, ErrProfileNotFound, "~/.config/altalabs/config.toml does not exist")
+
+		t.Setenv(EnvConfigFile, path)
+		profile, err := LoadProfile("lab")
+		require.NoError(t, err)
+		assert.Equal(t, "lab", profile.Name)
+	})
+
+	t.Run("Missing profiles should not be found", func(t *testing.T) {
+		_, err := LoadProfileFile(path, "staging")
+		require.ErrorIs(t, err, ErrProfileNotFound)
+	})
+
+	t.Run("Unknown keys should be rejected", func(t *testing.T) {
+		_, err := LoadProfileFile(path, "typo")
+		require.ErrorContains(t, err, `unknown key "usrname"`)
+	})
+
+	t.Run("Invalid values should be rejected", func(t *testing.T) {
+		for contents, message := range map[string]string{
+			"[profiles.default]\ncredentials = \"vault\"":    `unknown credentials source "vault"`,
+			"[profiles.default]\ntimeout = \"soon\"":         "invalid timeout",
+			"[profiles.default]\ntimeout = 1.5":              "timeout must be a duration string or a number of seconds",
+			"[profiles.default]\nsite = 42":                  "site must be a string",
+			"[profiles.default]\nsite = [1, 2]":              "site must be a string",
+			"[profiles.default]\nsite = \"unterminated":      "line 2",
+			"[profiles.default]\nsite = \"a\" \"b\"":         "line 2",
+			"default_profile = 1":                            "line 1",
+			"[profiles.default]\n[profiles.default]":         "line 2",
+			"[profiles.default]\nsite = \"a\"\nsite = \"b\"": "line 3",
+		} {
+			_, err := LoadProfileFile(writeConfigFile(t, contents), "default")
+			require.ErrorContains(t, err, message, contents)
+		}
+	})
+}
+
+func TestProfileCredentialsProvider(t *testing.T) {
+	clearCredentialsEnv(t)
+	ctx := context.Background()
+
+	credentialsPath := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentialsPath, []byte(testCredentialsFile), 0o600))
+
+	t.Run("Static", func(t *testing.T) {
+		profile := &Profile{Credentials: CredentialsSourceStatic, Username: "user", Password: "pass"}
+		creds, err := profile.CredentialsProvider().Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, Credentials{Username: "user", Password: "pass", Source: "static"}, creds)
+	})
+
+	t.Run("File should default to the profile name", func(t *testing.T) {
+		profile := &Profile{Name: "service", Credentials: CredentialsSourceFile, CredentialsFile: credentialsPath}
+		creds, err := profile.CredentialsProvider().Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh_token", creds.RefreshToken)
+
+		profile.CredentialsProfile = DefaultProfile
+		creds, err = profile.CredentialsProvider().Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "user@example.com", creds.Username)
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		profile := &Profile{Credentials: CredentialsSourceEnv}
+		_, err := profile.CredentialsProvider().Retrieve(ctx)
+		require.ErrorIs(t, err, ErrNoCredentials)
+
+		t.Setenv(EnvUsername, "env-user")
+		t.Setenv(EnvPassword, "env-pass")
+		creds, err := profile.CredentialsProvider().Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "env-user", creds.Username)
+	})
+
+	t.Run("Unset should try the environment then the file", func(t *testing.T) {
+		profile := &Profile{Name: DefaultProfile, CredentialsFile: credentialsPath}
+		creds, err := profile.CredentialsProvider().Retrieve(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "user@example.com", creds.Username)
+		assert.Equal(t, "file (profile default)", creds.Source)
+	})
+}
+
+func TestNewAltaClientFromProfile(t *testing.T) {
+	const (
+		username = "lab@example.com"
+		password = "correct horse"
+	)
+	clearCredentialsEnv(t)
+
+	cognito := newCognitoServer(t, "eu-west-2_LabPool", "lab-client")
+	cognito.AddUser(username, password)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer api.Close()
+
+	cache := filepath.Join(t.TempDir(), "tokens.json")
+	config := strings.Join([]string{
+		"[profiles.lab]",
+		`credentials = "static"`,
+		`username = "` + username + `"`,
+		`password = "` + password + `"`,
+		`endpoint = "` + api.URL + `/"`,
+		`cognito_endpoint = "` + cognito.URL + `"`,
+		`user_pool_id = "eu-west-2_LabPool"`,
+		`client_id = "lab-client"`,
+		`site = "site-lab"`,
+		`timeout = "10s"`,
+		`token_cache = "` + cache + `"`,
+	}, "\n")
+	t.Setenv(EnvConfigFile, writeConfigFile(t, config))
+
+	client, err := NewAltaClientFromProfile(context.Background(), "lab")
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Equal(t, api.URL+"/", client.Endpoint)
+	assert.Equal(t, "site-lab", client.DefaultSite())
+	assert.Equal(t, 10*time.Second, client.client.Timeout)
+
+	_, err = client.ListSitesContext(context.Background())
+	require.NoError(t, err)
+
+	_, err = os.Stat(cache)
+	require.NoError(t, err, "the session should be cached")
+
+	// Closing the client must leave the cached session usable by the next one
+	require.NoError(t, client.Close())
+	client, err = NewAltaClientFromProfile(context.Background(), "lab")
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.ListSitesContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, cognito.SignIns(), "the cached session should be resumed")
+
+	_, err = NewAltaClientFromProfile(context.Background(), "missing")
+	require.ErrorIs(t, err, ErrProfileNotFound)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter is a token bucket limiting the rate of API requests. It is safe for concurrent use and can be shared
+// between clients so that they draw from the same budget.
+type RateLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewRateLimiter returns a limiter allowing rps requests per second on average, with bursts of up to burst requests.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		limiter: rate.NewLimiter(rate.Limit(rps), max(burst, 1)),
+	}
+}
+
+// Wait blocks until a request is allowed or the context is done, returning the time spent waiting.
+func (l *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	err := l.limiter.Wait(ctx)
+	return time.Since(start), err
+}
+
+// RateLimitHook is called after a request has been delayed by the rate limiter.
+type RateLimitHook func(path string, wait time.Duration)
+
+// WithRateLimit limits requests to rps per second with bursts of up to burst requests. The limiter is created when
+// the option is, so clients created with the same option value share it.
+func WithRateLimit(rps float64, burst int) newAltaClientOptions {
+	return WithRateLimiter(NewRateLimiter(rps, burst))
+}
+
+// WithRateLimiter limits requests with the given limiter, which may be shared with other clients.
+func WithRateLimiter(limiter *RateLimiter) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.RateLimiter = limiter
+	}
+}
+
+// WithRateLimitHook sets a hook reporting how long requests were throttled by the rate limiter.
+func WithRateLimitHook(hook RateLimitHook) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.RateLimitHook = hook
+	}
+}
+
+// waitForRateLimit blocks until the request to path is allowed by the client's rate limiter, if any.
+func (a *AltaClient) waitForRateLimit(ctx context.Context, path string) error {
+	if a.rateLimiter == nil {
+		return nil
+	}
+
+	wait, err := a.rateLimiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Waits below a millisecond are scheduling noise rather than throttling
+	if a.rateLimitHook != nil && wait >= time.Millisecond {
+		a.rateLimitHook(path, wait)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	t.Run("Clients sharing an option should share the limiter", func(t *testing.T) {
+		requests.Store(0)
+
+		var mu sync.Mutex
+		var throttled []string
+		hook := WithRateLimitHook(func(path string, wait time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			throttled = append(throttled, path)
+		})
+
+		limit := WithRateLimit(20, 1)
+		clients := []*AltaClient{newTestClient(server, nil, limit, hook), newTestClient(server, nil, limit, hook)}
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := range 6 {
+			wg.Add(1)
+			go func(client *AltaClient) {
+				defer wg.Done()
+				_, err := client.ListSitesContext(context.Background())
+				assert.NoError(t, err)
+			}(clients[i%2])
+		}
+		wg.Wait()
+
+		// The first request uses the burst, the remaining five wait 50ms each
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		assert.Equal(t, int32(6), requests.Load())
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.NotEmpty(t, throttled)
+		assert.Equal(t, "sites/list", throttled[0])
+	})
+
+	t.Run("Waiting should stop when the context is done", func(t *testing.T) {
+		requests.Store(0)
+		client := newTestClient(server, nil, WithRateLimit(0.01, 1))
+
+		_, err := client.ListSitesContext(context.Background())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err = client.ListSitesContext(ctx)
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+const (
+	// refreshTimeout bounds a single refresh. A refresh is shared between callers so it isn't cancelled along with
+	// the caller that started it.
+	refreshTimeout = time.Minute
+
+	// backgroundRefreshWindow is how long before expiry the background refresher renews the tokens. Short-lived
+	// tokens are renewed once a fifth of their lifetime remains.
+	backgroundRefreshWindow = 5 * time.Minute
+
+	// backgroundRetryDelay is how long the background refresher waits after a failed refresh.
+	backgroundRetryDelay = 30 * time.Second
+)
+
+// ErrReauthRequired is returned when the tokens can't be renewed without signing in again, e.g. the refresh token
+// was rejected after the password was wiped.
+var ErrReauthRequired = errors.New("re-authentication required")
+
+// refresher is a running background refresher.
+type refresher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// WithBackgroundRefresh sets whether the client renews its tokens in the background shortly before they expire,
+// so requests don't wait on a refresh. Disabled by default, as it starts a goroutine that runs until Close is
+// called.
+func WithBackgroundRefresh(enabled bool) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.BackgroundRefresh = enabled
+	}
+}
+
+// WithWipePassword sets whether the client forgets the password once signed in. Tokens are then renewed with the
+// refresh token alone, failing with ErrReauthRequired once it expires or is revoked.
+func WithWipePassword(wipe bool) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.WipePassword = wipe
+	}
+}
+
+// refresh signs in again unless the tokens have been replaced since the caller read the stale token. Concurrent
+// callers share a single in-flight refresh, each waiting until it completes or their own context is done.
+func (auth *AuthClient) refresh(ctx context.Context, stale string) error {
+	result := auth.refreshGroup.DoChan("refresh", func() (any, error) {
+		if auth.GetIDToken() != stale {
+			return nil, nil
+		}
+
+		auth.refreshing.Lock()
+		defer auth.refreshing.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+		defer cancel()
+		return nil, auth.reauthenticate(ctx)
+	})
+
+	select {
+	case res := <-result:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reauthenticate renews the tokens with the refresh token, signing in again with the stored password if there is
+// no refresh token or it is rejected.
+func (auth *AuthClient) reauthenticate(ctx context.Context) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.refresh")
+	defer func() {
+		auth.tel().recordTokenRefresh(ctx, err)
+		auth.tel().endAuth(ctx, span, "auth.refresh", start, err)
+	}()
+
+	auth.mu.RLock()
+	config := auth.userConfig
+	var idToken, refreshToken string
+	if auth.auth != nil {
+		idToken = aws.ToString(auth.auth.IdToken)
+		refreshToken = aws.ToString(auth.auth.RefreshToken)
+	}
+	auth.mu.RUnlock()
+
+	hasPassword := config != nil && config.Password != ""
+
+	if refreshToken != "" {
+		err := auth.refreshWithToken(ctx, config, idToken, refreshToken)
+		if err == nil {
+			return nil
+		}
+
+		var notAuthorized *types.NotAuthorizedException
+		if !errors.As(err, &notAuthorized) {
+			return fmt.Errorf("failed to refresh auth: %w", err)
+		}
+		auth.deleteSession(ctx, config)
+		if !hasPassword {
+			return fmt.Errorf("%w: refresh token rejected: %w", ErrReauthRequired, err)
+		}
+	}
+
+	if !hasPassword {
+		return fmt.Errorf("%w: no refresh token or password", ErrReauthRequired)
+	}
+	if err := auth.signIn(ctx, config); err != nil {
+		return fmt.Errorf("failed to refresh auth: %w", err)
+	}
+
+	return nil
+}
+
+// refreshWithToken renews the tokens with the REFRESH_TOKEN_AUTH flow. idToken is the ID token issued with the
+// refresh token, if known.
+func (auth *AuthClient) refreshWithToken(ctx context.Context, config *Config, idToken, refreshToken string) error {
+	params := map[string]string{
+		"REFRESH_TOKEN": refreshToken,
+	}
+	if auth.clientSecret != nil {
+		params["SECRET_HASH"] = secretHash(refreshUsername(config, idToken), auth.clientID, *auth.clientSecret)
+	}
+	// Refresh tokens issued to a remembered device are only accepted along with its key
+	if device := auth.GetRememberedDevice(); device != nil {
+		params["DEVICE_KEY"] = device.Key
+	}
+
+	resp, err := auth.cognito.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
+		AuthFlow:       types.AuthFlowTypeRefreshTokenAuth,
+		ClientId:       &auth.clientID,
+		AuthParameters: params,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initiate refresh: %w", err)
+	}
+	if resp.AuthenticationResult == nil {
+		return errors.New("refresh returned no tokens, challenge: " + string(resp.ChallengeName))
+	}
+
+	// Refreshes don't return a new refresh token, the current one stays valid
+	result := resp.AuthenticationResult
+	if result.RefreshToken == nil {
+		result.RefreshToken = &refreshToken
+	}
+
+	auth.setAuth(config, result)
+	auth.storeSession(ctx)
+	return nil
+}
+
+// refreshUsername returns the username the SECRET_HASH of a refresh is computed from. That's the cognito:username
+// of the ID token, as the config holds the alias signed in with, or nothing for sessions resumed from tokens.
+func refreshUsername(config *Config, idToken string) string {
+	if claims, err := ParseClaims(idToken); err == nil && claims.Username != "" {
+		return claims.Username
+	}
+	if config != nil {
+		return config.Username
+	}
+	return ""
+}
+
+// secretHash computes the SECRET_HASH Cognito requires from clients with a client secret.
+func secretHash(username, clientID, clientSecret string) string {
+	mac := hmac.New(sha256.New, []byte(clientSecret))
+	mac.Write([]byte(username + clientID))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// StartBackgroundRefresh renews the tokens in the background shortly before they expire, so requests don't wait
+// on a refresh. It does nothing if the refresher is already running.
+func (auth *AuthClient) StartBackgroundRefresh() {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	if auth.refresher != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	auth.refresher = &refresher{cancel: cancel, done: make(chan struct{})}
+	go auth.runRefresher(ctx, auth.refresher)
+}
+
+// StopBackgroundRefresh stops the background refresher and waits for it to exit.
+func (auth *AuthClient) StopBackgroundRefresh() {
+	auth.mu.Lock()
+	r := auth.refresher
+	auth.refresher = nil
+	auth.mu.Unlock()
+
+	if r == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+func (auth *AuthClient) runRefresher(ctx context.Context, r *refresher) {
+	defer close(r.done)
+
+	timer := time.NewTimer(auth.nextRefresh(time.Now()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// The tokens may have been renewed by a request while waiting
+		if next := auth.nextRefresh(time.Now()); next > 0 {
+			timer.Reset(next)
+			continue
+		}
+
+		next := backgroundRetryDelay
+		err := auth.refresh(ctx, auth.GetIDToken())
+		switch {
+		case err == nil:
+			// Don't spin on tokens with no lifetime
+			next = max(auth.nextRefresh(time.Now()), time.Second)
+		case errors.Is(err, ErrReauthRequired):
+			// Retrying can't help, requests will report the error. Forget the refresher so it can be started again
+			// once signed in.
+			auth.mu.Lock()
+			if auth.refresher == r {
+				auth.refresher = nil
+			}
+			auth.mu.Unlock()
+			return
+		}
+		timer.Reset(next)
+	}
+}
+
+// nextRefresh returns how long until the background refresher should renew the tokens.
+func (auth *AuthClient) nextRefresh(now time.Time) time.Duration {
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+
+	if auth.auth == nil {
+		return 0
+	}
+
+	window := backgroundRefreshWindow
+	if lifetime := auth.lifetime(); lifetime > 0 {
+		window = min(window, lifetime/5)
+	}
+	return max(auth.expiry.Sub(now)-window, 0)
+}
+
+// lifetime returns how long the tokens were issued for, or zero if unknown. Sessions created from tokens have no
+// ExpiresIn, so it's taken from the ID token's iat and exp claims. The caller must hold mu.
+func (auth *AuthClient) lifetime() time.Duration {
+	if auth.auth.ExpiresIn > 0 {
+		return time.Duration(auth.auth.ExpiresIn) * time.Second
+	}
+	if auth.auth.IdToken == nil {
+		return 0
+	}
+
+	claims, err := ParseClaims(*auth.auth.IdToken)
+	if err != nil || claims.IssuedAt.IsZero() || claims.ExpiresAt.IsZero() {
+		return 0
+	}
+	return claims.ExpiresAt.Sub(claims.IssuedAt)
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentRefresh(t *testing.T) {
+	staleToken := "stale_token"
+	freshToken := "fresh_token"
+	const concurrency = 300
+
+	var rejected atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Token") != freshToken {
+			rejected.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`"Unauthorized"`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":"site","name":"home"}]`))
+	}))
+	defer server.Close()
+
+	listSitesConcurrently := func(t *testing.T, client *AltaClient) {
+		var wg sync.WaitGroup
+		errs := make(chan error, concurrency)
+		for range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sites, err := client.ListSitesContext(context.Background())
+				if err == nil && len(sites) != 1 {
+					t.Errorf("expected 1 site, got %d", len(sites))
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+	}
+
+	t.Run("Requests with an expired token should share a single sign in", func(t *testing.T) {
+		cognito := newFakeCognito(freshToken)
+		cognito.delay = 50 * time.Millisecond
+		client := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now().Add(-time.Second)))
+
+		listSitesConcurrently(t, client)
+
+		assert.Equal(t, 1, cognito.SignIns())
+		assert.Equal(t, freshToken, client.AuthClient.GetIDToken())
+	})
+
+	t.Run("Requests rejected as unauthorized should share a single sign in", func(t *testing.T) {
+		rejected.Store(0)
+		cognito := newFakeCognito(freshToken)
+		cognito.delay = 50 * time.Millisecond
+		client := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now().Add(time.Minute)))
+
+		listSitesConcurrently(t, client)
+
+		assert.Positive(t, rejected.Load())
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+
+	t.Run("Waiting callers should give up when their context is done", func(t *testing.T) {
+		cognito := newFakeCognito(freshToken)
+		cognito.delay = 200 * time.Millisecond
+		client := newTestClient(server, newSignedInAuthClient(cognito, staleToken, time.Now().Add(-time.Second)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, client.AuthClient.RefreshAuthContext(ctx), context.DeadlineExceeded)
+
+		// The shared refresh carries on for the other callers
+		assert.Eventually(t, func() bool {
+			return client.AuthClient.GetIDToken() == freshToken
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+}
+
+func TestBackgroundRefresh(t *testing.T) {
+	cognito := newFakeCognito("id_token")
+	cognito.expiresIn = 1
+
+	auth := &AuthClient{
+		authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+		cognito:    cognito,
+	}
+	require.NoError(t, auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password")))
+
+	auth.StartBackgroundRefresh()
+	auth.StartBackgroundRefresh()
+
+	assert.Eventually(t, func() bool {
+		return cognito.Refreshes() >= 2
+	}, 5*time.Second, 10*time.Millisecond, "tokens weren't refreshed in the background")
+
+	auth.StopBackgroundRefresh()
+	auth.StopBackgroundRefresh()
+
+	refreshes := cognito.Refreshes()
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, refreshes, cognito.Refreshes(), "refresher kept running after being stopped")
+	assert.Equal(t, 1, cognito.SignIns())
+}
+
+func TestNextRefresh(t *testing.T) {
+	now := time.Now()
+	token := "id_token"
+
+	tests := []struct {
+		name      string
+		expiresIn int32
+		remaining int32
+		expected  time.Duration
+	}{
+		{"long-lived token", 3600, 3600, 55 * time.Minute},
+		{"short-lived token", 60, 60, 48 * time.Second},
+		{"expired token", 3600, -10, 0},
+		{"within the window", 3600, 60, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &AuthClient{
+				auth:   &types.AuthenticationResultType{IdToken: &token, ExpiresIn: tt.expiresIn},
+				expiry: now.Add(time.Duration(tt.remaining) * time.Second),
+			}
+			assert.InDelta(t, tt.expected, auth.nextRefresh(now), float64(time.Second))
+		})
+	}
+
+	assert.Zero(t, (&AuthClient{}).nextRefresh(now))
+
+	t.Run("Sessions from tokens should take the lifetime from the claims", func(t *testing.T) {
+		for lifetime, expected := range map[time.Duration]time.Duration{
+			time.Hour:   55 * time.Minute,
+			time.Minute: 48 * time.Second,
+		} {
+			token := newTestJWT(map[string]any{"iat": now.Unix(), "exp": now.Add(lifetime).Unix()})
+			auth := &AuthClient{
+				auth:   &types.AuthenticationResultType{IdToken: &token},
+				expiry: now.Add(lifetime),
+			}
+			assert.InDelta(t, expected, auth.nextRefresh(now), float64(time.Second), lifetime.String())
+		}
+	})
+
+	t.Run("Tokens without an issue time should use the default window", func(t *testing.T) {
+		auth := &AuthClient{
+			auth:   &types.AuthenticationResultType{IdToken: &token},
+			expiry: now.Add(time.Hour),
+		}
+		assert.InDelta(t, time.Hour-backgroundRefreshWindow, auth.nextRefresh(now), float64(time.Second))
+	})
+}
+
+func TestRefreshTokenAuth(t *testing.T) {
+	newTestAuthClient := func(t *testing.T, cognito cognitoClient, config *Config) *AuthClient {
+		auth := &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			cognito:    cognito,
+		}
+		require.NoError(t, auth.SignInContext(context.Background(), config))
+		return auth
+	}
+
+	t.Run("Refreshes should use the refresh token", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(t, cognito, NewConfig().WithSRPAuth("username", "password"))
+
+		cognito.idToken = "refreshed_token"
+		require.NoError(t, auth.RefreshAuthContext(context.Background()))
+		assert.Equal(t, "refreshed_token", auth.GetIDToken())
+
+		// Refresh responses don't include a refresh token, the original must be kept for the next refresh
+		require.NoError(t, auth.RefreshAuthContext(context.Background()))
+		assert.Equal(t, 2, cognito.Refreshes())
+		assert.Equal(t, 1, cognito.SignIns())
+		assert.NotContains(t, cognito.refreshParams, "SECRET_HASH")
+	})
+
+	t.Run("A rejected refresh token should fall back to the password", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(t, cognito, NewConfig().WithSRPAuth("username", "password"))
+
+		cognito.rejectRefresh = true
+		require.NoError(t, auth.RefreshAuthContext(context.Background()))
+		assert.Equal(t, 0, cognito.Refreshes())
+		assert.Equal(t, 2, cognito.SignIns())
+	})
+
+	t.Run("A rejected refresh token should require re-authentication once the password is wiped", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		config := NewConfig().WithSRPAuth("username", "password")
+		auth := newTestAuthClient(t, cognito, config)
+
+		auth.WipePassword()
+		assert.Equal(t, "password", config.Password, "the caller's config must not be modified")
+
+		require.NoError(t, auth.RefreshAuthContext(context.Background()))
+		assert.Equal(t, 1, cognito.Refreshes())
+
+		cognito.rejectRefresh = true
+		err := auth.RefreshAuthContext(context.Background())
+		require.ErrorIs(t, err, ErrReauthRequired)
+
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized)
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+
+	t.Run("Wiping the password should not race with refreshes", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(t, cognito, NewConfig().WithSRPAuth("username", "password"))
+		cognito.rejectRefresh = true
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				_ = auth.RefreshAuthContext(context.Background())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				auth.WipePassword()
+			}
+		}()
+		wg.Wait()
+
+		// A refresh that read the config before the wipe may sign in with the password once more
+		auth.WipePassword()
+		require.ErrorIs(t, auth.RefreshAuthContext(context.Background()), ErrReauthRequired)
+	})
+
+	t.Run("Transient refresh failures should not fall back to the password", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		auth := newTestAuthClient(t, cognito, NewConfig().WithSRPAuth("username", "password"))
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.Error(t, auth.reauthenticate(cancelled))
+		assert.Equal(t, 1, cognito.SignIns())
+	})
+
+	t.Run("Refreshes should be signed with the client secret", func(t *testing.T) {
+		cognito := newFakeCognito("id_token")
+		secret := "client_secret"
+		auth := &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client", clientSecret: &secret},
+			cognito:    cognito,
+		}
+		require.NoError(t, auth.SignInContext(context.Background(), NewConfig().WithSRPAuth("username", "password")))
+		require.NoError(t, auth.RefreshAuthContext(context.Background()))
+
+		// HMAC-SHA256 of username+clientID keyed with the client secret
+		assert.Equal(t, "7PK7xj74egC8MDz2EKUkPNb5sl4vp7HF7FtDHeZMJnM=", cognito.refreshParams["SECRET_HASH"])
+	})
+}
+
+func TestRefreshClientSecret(t *testing.T) {
+	const (
+		username = "3f2c9e1a-user"
+		alias    = "user@example.com"
+		password = "correct horse"
+	)
+	ctx := context.Background()
+
+	cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+	cognito.clientSecret = "client-secret"
+	cognito.AddUser(username, password)
+	cognito.AddAlias(alias, username)
+
+	signIn := func(t *testing.T) *AuthClient {
+		auth, err := NewAuthClient("", cognito.authOptions()...)
+		require.NoError(t, err)
+		require.NoError(t, auth.SignInContext(ctx, NewConfig().WithSRPAuth(alias, password)))
+		return auth
+	}
+
+	t.Run("Sessions signed in with an alias should be refreshed as the user", func(t *testing.T) {
+		auth := signIn(t)
+		auth.WipePassword()
+		refreshes := cognito.Refreshes()
+
+		require.NoError(t, auth.RefreshAuthContext(ctx))
+		assert.Equal(t, refreshes+1, cognito.Refreshes())
+	})
+
+	t.Run("Sessions resumed from tokens should be refreshed as the user", func(t *testing.T) {
+		issuer := signIn(t)
+		refreshes := cognito.Refreshes()
+
+		client, err := NewAltaClientWithCredentials(ctx, StaticCredentialsProvider{Credentials: Credentials{
+			Username:     alias,
+			IDToken:      issuer.GetIDToken(),
+			RefreshToken: *issuer.auth.RefreshToken,
+		}}, WithAuthOptions(cognito.authOptions()...))
+		require.NoError(t, err)
+
+		require.NoError(t, client.AuthClient.RefreshAuthContext(ctx))
+		assert.Equal(t, refreshes+1, cognito.Refreshes())
+	})
+}
+
+func TestBackgroundRefreshReauthRequired(t *testing.T) {
+	token := "id_token"
+	auth := &AuthClient{
+		authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+		cognito:    newFakeCognito("id_token"),
+		auth:       &types.AuthenticationResultType{IdToken: &token},
+		expiry:     time.Now(),
+	}
+
+	auth.StartBackgroundRefresh()
+	defer auth.StopBackgroundRefresh()
+
+	// The refresher forgets itself as it exits, so it can be started again once signed in
+	assert.Eventually(t, func() bool {
+		auth.mu.RLock()
+		defer auth.mu.RUnlock()
+		return auth.refresher == nil
+	}, 5*time.Second, 10*time.Millisecond, "refresher kept retrying without a way to renew the tokens")
+}
+
+func TestBackgroundRefreshOption(t *testing.T) {
+	const (
+		username = "user@example.com"
+		password = "correct horse"
+	)
+
+	cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+	cognito.AddUser(username, password)
+
+	running := func(client *AltaClient) bool {
+		client.AuthClient.mu.RLock()
+		defer client.AuthClient.mu.RUnlock()
+		return client.AuthClient.refresher != nil
+	}
+
+	t.Run("Background refresh should be off by default", func(t *testing.T) {
+		client, err := NewAltaClientContext(context.Background(), username, password, WithAuthOptions(cognito.authOptions()...))
+		require.NoError(t, err)
+		assert.False(t, running(client))
+	})
+
+	t.Run("Background refresh should run until Close", func(t *testing.T) {
+		client, err := NewAltaClientContext(context.Background(), username, password, WithAuthOptions(cognito.authOptions()...),
+			WithBackgroundRefresh(true))
+		require.NoError(t, err)
+		assert.True(t, running(client))
+
+		require.NoError(t, client.Close())
+		assert.False(t, running(client))
+	})
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// deviceSecretSize is the number of random bytes in a device's password.
+const deviceSecretSize = 40
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+// RememberedDevice is a device remembered by Cognito. It proves itself with SRP using its secret, which Cognito
+// accepts in place of MFA, like "remember this device" in the web UI.
+type RememberedDevice struct {
+	Key      string `json:"key"`
+	GroupKey string `json:"groupKey"`
+	Secret   string `json:"secret"` // Device password, only its verifier is sent to Cognito
+}
+
+// DeviceStore persists remembered devices. Both MemoryTokenStore and FileTokenStore implement it, keeping devices
+// alongside sessions. Implementations must be safe for concurrent use.
+type DeviceStore interface {
+	// LoadDevice returns the stored device, or ErrDeviceNotFound if there isn't one.
+	LoadDevice(ctx context.Context, key SessionKey) (*RememberedDevice, error)
+	SaveDevice(ctx context.Context, key SessionKey, device *RememberedDevice) error
+	DeleteDevice(ctx context.Context, key SessionKey) error
+}
+
+// WithDeviceStore enables device tracking: devices issued by Cognito are confirmed and remembered, then used to
+// skip MFA on later sign ins.
+func WithDeviceStore(store DeviceStore) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.DeviceStore = store
+	}
+}
+
+// WithAuthDeviceStore enables device tracking: devices issued by Cognito are confirmed and remembered, then used
+// to skip MFA on later sign ins.
+func WithAuthDeviceStore(store DeviceStore) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.DeviceStore = store
+	}
+}
+
+// WithDeviceName sets the name devices are confirmed with, shown in the account's device list.
+func WithDeviceName(name string) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.DeviceName = name
+	}
+}
+
+// defaultDeviceName names devices after the host.
+func defaultDeviceName() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "altalabs-go"
+}
+
+func (s *MemoryTokenStore) LoadDevice(_ context.Context, key SessionKey) (*RememberedDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[key]
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+	return &device, nil
+}
+
+func (s *MemoryTokenStore) SaveDevice(_ context.Context, key SessionKey, device *RememberedDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices[key] = *device
+	return nil
+}
+
+func (s *MemoryTokenStore) DeleteDevice(_ context.Context, key SessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.devices, key)
+	return nil
+}
+
+func (s *FileTokenStore) LoadDevice(_ context.Context, key SessionKey) (*RememberedDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contents, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	device, ok := contents.Devices[key.String()]
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+	return &device, nil
+}
+
+func (s *FileTokenStore) SaveDevice(_ context.Context, key SessionKey, device *RememberedDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(contents *fileStoreContents) bool {
+		contents.Devices[key.String()] = *device
+		return true
+	})
+}
+
+func (s *FileTokenStore) DeleteDevice(_ context.Context, key SessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(contents *fileStoreContents) bool {
+		if _, ok := contents.Devices[key.String()]; !ok {
+			return false
+		}
+		delete(contents.Devices, key.String())
+		return true
+	})
+}
+
+// GetRememberedDevice returns the remembered device in use, or nil if device tracking is off or no device is remembered yet.
+func (auth *AuthClient) GetRememberedDevice() *RememberedDevice {
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+
+	if auth.device == nil {
+		return nil
+	}
+	device := *auth.device
+	return &device
+}
+
+func (auth *AuthClient) setDevice(device *RememberedDevice) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	auth.device = device
+}
+
+// loadDevice restores the user's remembered device. Store failures are recorded on the span and the sign in
+// continues without a device.
+func (auth *AuthClient) loadDevice(ctx context.Context, config *Config) *RememberedDevice {
+	if auth.deviceStore == nil {
+		return nil
+	}
+
+	device, err := auth.deviceStore.LoadDevice(ctx, auth.sessionKey(config))
+	if err != nil {
+		if !errors.Is(err, ErrDeviceNotFound) {
+			trace.SpanFromContext(ctx).RecordError(fmt.Errorf("failed to load device: %w", err))
+		}
+		device = nil
+	}
+
+	auth.setDevice(device)
+	return device
+}
+
+// forgetDevice drops a device Cognito no longer recognises.
+func (auth *AuthClient) forgetDevice(ctx context.Context, config *Config) {
+	auth.setDevice(nil)
+	if err := auth.deviceStore.DeleteDevice(ctx, auth.sessionKey(config)); err != nil {
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("failed to delete device: %w", err))
+	}
+}
+
+// confirmDevice registers the device issued with a sign in, generating its secret and marking it remembered, then
+// stores it. Failures are recorded on the span rather than failing the sign in.
+func (auth *AuthClient) confirmDevice(ctx context.Context, config *Config, result *types.AuthenticationResultType) {
+	if auth.deviceStore == nil || result.NewDeviceMetadata == nil || result.AccessToken == nil {
+		return
+	}
+
+	if err := auth.rememberDevice(ctx, config, result); err != nil {
+		trace.SpanFromContext(ctx).RecordError(fmt.Errorf("failed to confirm device: %w", err))
+	}
+}
+
+func (auth *AuthClient) rememberDevice(ctx context.Context, config *Config, result *types.AuthenticationResultType) error {
+	secret := make([]byte, deviceSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate device secret: %w", err)
+	}
+
+	device := &RememberedDevice{
+		Key:      aws.ToString(result.NewDeviceMetadata.DeviceKey),
+		GroupKey: aws.ToString(result.NewDeviceMetadata.DeviceGroupKey),
+		Secret:   base64.StdEncoding.EncodeToString(secret),
+	}
+
+	salt, verifier, err := srpVerifier(device.GroupKey, device.Key, device.Secret)
+	if err != nil {
+		return err
+	}
+
+	name := auth.deviceName
+	if name == "" {
+		name = defaultDeviceName()
+	}
+
+	resp, err := auth.cognito.ConfirmDevice(ctx, &cognitoidentityprovider.ConfirmDeviceInput{
+		AccessToken: result.AccessToken,
+		DeviceKey:   aws.String(device.Key),
+		DeviceName:  aws.String(name),
+		DeviceSecretVerifierConfig: &types.DeviceSecretVerifierConfigType{
+			PasswordVerifier: aws.String(base64.StdEncoding.EncodeToString(srpBytes(verifier))),
+			Salt:             aws.String(base64.StdEncoding.EncodeToString(srpBytes(salt))),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Pools set to remember devices on user opt-in leave the device unremembered until asked
+	if resp.UserConfirmationNecessary {
+		_, err := auth.cognito.UpdateDeviceStatus(ctx, &cognitoidentityprovider.UpdateDeviceStatusInput{
+			AccessToken:            result.AccessToken,
+			DeviceKey:              aws.String(device.Key),
+			DeviceRememberedStatus: types.DeviceRememberedStatusTypeRemembered,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remember device: %w", err)
+		}
+	}
+
+	auth.setDevice(device)
+	return auth.deviceStore.SaveDevice(ctx, auth.sessionKey(config), device)
+}
+
+// answerDeviceChallenge proves the remembered device with SRP, answering DEVICE_SRP_AUTH and then
+// DEVICE_PASSWORD_VERIFIER.
+func (auth *AuthClient) answerDeviceChallenge(config *Config, device *RememberedDevice, srp *srpClient, step challengeStep) (map[string]string, error) {
+	if device == nil {
+		return nil, fmt.Errorf("%w received: %s, no remembered device", ErrUnhandledChallenge, step.name)
+	}
+
+	username := step.parameters["USERNAME"]
+	if username == "" {
+		username = config.Username
+	}
+	responses := map[string]string{
+		"USERNAME":   username,
+		"DEVICE_KEY": device.Key,
+	}
+	if auth.clientSecret != nil {
+		responses["SECRET_HASH"] = secretHash(username, auth.clientID, *auth.clientSecret)
+	}
+
+	switch step.name {
+	case types.ChallengeNameTypeDeviceSrpAuth:
+		responses["SRP_A"] = srp.A.Text(16)
+
+	case types.ChallengeNameTypeDevicePasswordVerifier:
+		timestamp, signature, err := srp.passwordClaim(device.GroupKey, device.Key, device.Secret, step.parameters, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify device: %w", err)
+		}
+		responses["TIMESTAMP"] = timestamp
+		responses["PASSWORD_CLAIM_SECRET_BLOCK"] = step.parameters["SECRET_BLOCK"]
+		responses["PASSWORD_CLAIM_SIGNATURE"] = signature
+	}
+
+	return responses, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRememberedDevice(t *testing.T) {
+	const (
+		username = "automation@example.com"
+		password = "correct horse"
+		secret   = "JBSWY3DPEHPK3PXP"
+	)
+
+	newServer := func(t *testing.T) *cognitoServer {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.deviceTracking = true
+		cognito.AddMFAUser(username, password, secret)
+		return cognito
+	}
+	signIn := func(cognito *cognitoServer, store DeviceStore, opts ...newAltaClientOptions) (*AltaClient, error) {
+		opts = append([]newAltaClientOptions{
+			WithAuthOptions(append(cognito.authOptions(), WithDeviceName("ci-runner"))...),
+			WithDeviceStore(store),
+		}, opts...)
+		return NewAltaClientContext(context.Background(), username, password, opts...)
+	}
+
+	t.Run("A remembered device should skip MFA", func(t *testing.T) {
+		cognito := newServer(t)
+		store := NewMemoryTokenStore()
+
+		client, err := signIn(cognito, store, WithChallengeHandler(NewTOTPChallengeHandler(secret)))
+		require.NoError(t, err)
+		assert.Equal(t, 1, cognito.MFAChallenges())
+		assert.Equal(t, 1, cognito.RememberedDevices(username))
+
+		device := client.AuthClient.GetRememberedDevice()
+		require.NotNil(t, device)
+		stored, err := store.LoadDevice(context.Background(), SessionKey{UserPoolID: "eu-west-2_TestPool", Username: username})
+		require.NoError(t, err)
+		assert.Equal(t, device, stored)
+
+		// No challenge handler, an MFA challenge would fail the sign in
+		client, err = signIn(cognito, store)
+		require.NoError(t, err)
+		assert.Equal(t, 1, cognito.MFAChallenges())
+		assert.Equal(t, 2, cognito.SignIns())
+		assert.Equal(t, device, client.AuthClient.GetRememberedDevice())
+
+		require.NoError(t, client.AuthClient.RefreshAuthContext(context.Background()), "refreshes should send the device key")
+		assert.Equal(t, 1, cognito.Refreshes())
+	})
+
+	t.Run("Without a device store devices should not be confirmed", func(t *testing.T) {
+		cognito := newServer(t)
+
+		client, err := NewAltaClientContext(context.Background(), username, password,
+			WithAuthOptions(cognito.authOptions()...), WithChallengeHandler(NewTOTPChallengeHandler(secret)))
+		require.NoError(t, err)
+		assert.Nil(t, client.AuthClient.GetRememberedDevice())
+		assert.Equal(t, 0, cognito.RememberedDevices(username))
+	})
+
+	t.Run("A forgotten device should be replaced", func(t *testing.T) {
+		cognito := newServer(t)
+		store := NewMemoryTokenStore()
+
+		client, err := signIn(cognito, store, WithChallengeHandler(NewTOTPChallengeHandler(secret)))
+		require.NoError(t, err)
+		forgotten := client.AuthClient.GetRememberedDevice()
+		require.NotNil(t, forgotten)
+		cognito.ForgetDevice(forgotten.Key)
+
+		client, err = signIn(cognito, store, WithChallengeHandler(NewTOTPChallengeHandler(secret)))
+		require.NoError(t, err)
+		assert.Equal(t, 2, cognito.MFAChallenges())
+
+		replacement := client.AuthClient.GetRememberedDevice()
+		require.NotNil(t, replacement)
+		assert.NotEqual(t, forgotten.Key, replacement.Key)
+	})
+
+	t.Run("A wrong device secret should be rejected", func(t *testing.T) {
+		cognito := newServer(t)
+		store := NewMemoryTokenStore()
+
+		client, err := signIn(cognito, store, WithChallengeHandler(NewTOTPChallengeHandler(secret)))
+		require.NoError(t, err)
+
+		device := client.AuthClient.GetRememberedDevice()
+		device.Secret = "not the secret"
+		key := SessionKey{UserPoolID: "eu-west-2_TestPool", Username: username}
+		require.NoError(t, store.SaveDevice(context.Background(), key, device))
+
+		_, err = signIn(cognito, store)
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized)
+	})
+
+	t.Run("Devices should work with app client secrets", func(t *testing.T) {
+		cognito := newServer(t)
+		cognito.clientSecret = "client-secret"
+		store := NewMemoryTokenStore()
+
+		_, err := signIn(cognito, store, WithChallengeHandler(NewTOTPChallengeHandler(secret)))
+		require.NoError(t, err)
+		_, err = signIn(cognito, store)
+		require.NoError(t, err)
+		assert.Equal(t, 1, cognito.MFAChallenges())
+	})
+}
+
+func TestFileDeviceStore(t *testing.T) {
+	ctx := context.Background()
+	key := SessionKey{UserPoolID: "us-east-1_abc123", Username: "user"}
+	device := &RememberedDevice{Key: "us-east-1_device", GroupKey: "-group", Secret: "secret"}
+
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store := NewEncryptedFileTokenStore(path, "passphrase")
+	store.iterations = 1
+
+	_, err := store.LoadDevice(ctx, key)
+	require.ErrorIs(t, err, ErrDeviceNotFound)
+
+	require.NoError(t, store.SaveDevice(ctx, key, device))
+	require.NoError(t, store.Save(ctx, key, &Session{IDToken: "id_token"}))
+	require.NoError(t, store.Delete(ctx, key))
+
+	loaded, err := store.LoadDevice(ctx, key)
+	require.NoError(t, err, "deleting the session should keep the device")
+	assert.Equal(t, device, loaded)
+
+	require.NoError(t, store.DeleteDevice(ctx, key))
+	_, err = store.LoadDevice(ctx, key)
+	require.ErrorIs(t, err, ErrDeviceNotFound)
+}
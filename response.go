@@ -0,0 +1,99 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// DefaultMaxResponseBytes is the default limit on the size of a response body.
+	DefaultMaxResponseBytes = 32 << 20
+
+	// maxDrainBytes is how much of an unread body is discarded so the connection can be reused. Larger bodies are
+	// cheaper to abandon along with their connection.
+	maxDrainBytes = 256 << 10
+)
+
+// ErrResponseTooLarge is returned when a response body exceeds the client's limit.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// WithMaxResponseBytes limits the size of response bodies read by the client. Defaults to DefaultMaxResponseBytes.
+func WithMaxResponseBytes(limit int64) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.MaxResponseBytes = limit
+	}
+}
+
+func (a *AltaClient) maxResponseBytes() int64 {
+	if a.maxResponse > 0 {
+		return a.maxResponse
+	}
+	return DefaultMaxResponseBytes
+}
+
+// readBody reads up to limit bytes of the body, then drains and closes it. A body exceeding the limit returns the
+// bytes read so far along with ErrResponseTooLarge.
+func readBody(body io.ReadCloser, limit int64) ([]byte, error) {
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+
+	defer func() {
+		// Reading to EOF lets the transport reuse the connection
+		_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+		_ = body.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return data, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return data[:limit], fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, limit)
+	}
+
+	return data, nil
+}
+
+// handleResponse consumes the response, returning an *APIError for non-200 responses and otherwise decoding the
+// body into dest. The body is always drained and closed. Empty bodies are accepted and leave dest untouched.
+func (a *AltaClient) handleResponse(req *Request, resp *http.Response, dest interface{}) error {
+	body, err := readBody(resp.Body, a.maxResponseBytes())
+
+	if resp.StatusCode != http.StatusOK {
+		// A truncated error body is still worth reporting
+		return newAPIError(req.Method, req.Path, resp, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dest == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(body, dest); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponsePipeline(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sites/list":
+			_, _ = w.Write([]byte(`[{"id":"site","name":"home"}]`))
+		case "/site":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"site not found"}`))
+		case "/sites/rename":
+			// Responses to mutations are ignored by the client, but must still be drained
+			_, _ = w.Write([]byte(strings.Repeat(" ", 16<<10) + `{"ok":true}`))
+		case "/group/add":
+			// Empty 200 responses are accepted
+		case "/wifi/ssid/list":
+			_, _ = w.Write([]byte(`{"ssids":[` + strings.Repeat(`{"ssid":"ssid"},`, 1000) + `{"ssid":"ssid"}]}`))
+		}
+	}))
+
+	var connections atomic.Int32
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	t.Run("Bodies should be closed and drained so connections are reused", func(t *testing.T) {
+		connections.Store(0)
+		goroutines := runtime.NumGoroutine()
+
+		// A transport of its own, so only this client's connections are counted
+		client := newTestClient(server, nil, WithHTTPClient(&http.Client{Transport: &http.Transport{}}))
+		for range 20 {
+			sites, err := client.ListSitesContext(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, sites, 1)
+
+			_, err = client.GetSiteContext(context.Background(), "site")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, client.RenameSiteByIDContext(context.Background(), "site", "name"))
+
+			_, err = client.AddGroupContext(context.Background(), "group")
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, int32(1), connections.Load())
+
+		client.client.CloseIdleConnections()
+
+		// Polled from the test goroutine, assert.Eventually runs its condition in a goroutine of its own
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "goroutines leaked")
+	})
+
+	t.Run("Error bodies should be decoded into the error", func(t *testing.T) {
+		_, err := newTestClient(server, nil).GetSiteContext(context.Background(), "site")
+
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, "site not found", apiErr.Message)
+		assert.Equal(t, []byte(`{"message":"site not found"}`), apiErr.Body)
+	})
+
+	t.Run("Bodies over the limit should be rejected", func(t *testing.T) {
+		_, err := newTestClient(server, nil, WithMaxResponseBytes(1024)).ListSSIDContext(context.Background())
+		require.ErrorIs(t, err, ErrResponseTooLarge)
+
+		ssids, err := newTestClient(server, nil).ListSSIDContext(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, ssids.SSIDs, 1001)
+	})
+}
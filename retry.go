@@ -0,0 +1,140 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Only transient failures are retried: network errors,
+// http-429 and http-502/503/504 responses.
+//
+// GET requests are retried automatically as they are idempotent. POST requests mutate state and are only retried
+// if RetryMutations is set.
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts including the first, values below 2 disable retries
+	BaseDelay      time.Duration // Backoff before the first retry, doubled for each subsequent retry
+	MaxDelay       time.Duration // Upper bound on a single backoff, including any Retry-After requested by the API
+	RetryMutations bool          // Also retry POST requests
+}
+
+// DefaultRetryPolicy returns a policy suitable for most callers: three attempts with a backoff of up to five seconds.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   250 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// WithRetryPolicy enables retries of transient failures. Retries are disabled unless this option is set.
+func WithRetryPolicy(policy RetryPolicy) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.RetryPolicy = policy
+	}
+}
+
+// attempts returns the number of attempts allowed for a request with the given method.
+func (p RetryPolicy) attempts(method string) int {
+	if method != http.MethodGet && !p.RetryMutations {
+		return 1
+	}
+	return max(p.MaxAttempts, 1)
+}
+
+// backoff returns the delay before the given retry (starting at 1), using exponential backoff with full jitter.
+// A Retry-After sent by the API takes precedence if it is longer. ok is false if the API asked for a longer wait
+// than MaxDelay allows.
+func (p RetryPolicy) backoff(retry int, err error) (delay time.Duration, ok bool) {
+	ceiling := p.BaseDelay << (retry - 1)
+	if ceiling <= 0 || (p.MaxDelay > 0 && ceiling > p.MaxDelay) {
+		ceiling = p.MaxDelay
+	}
+	if ceiling > 0 {
+		delay = rand.N(ceiling + 1)
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if retryAfter, found := parseRetryAfter(apiErr.Header.Get("Retry-After"), time.Now()); found {
+			if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+				return 0, false
+			}
+			delay = max(delay, retryAfter)
+		}
+	}
+
+	return delay, true
+}
+
+// isRetryable reports whether err is a transient failure worth retrying.
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	// Errors from http.Client.Do are always *url.Error, URL parsing errors are reported with the "parse" op
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && urlErr.Op != "parse"
+}
+
+// parseRetryAfter parses a Retry-After header value in either delay-seconds or http-date form.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// sleep waits for the delay, returning early with the context's error if it is cancelled.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	// failures is the number of http-502 responses returned before succeeding
+	var failures, requests atomic.Int32
+	var retryAfter atomic.Value
+	retryAfter.Store("")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures.Load() {
+			if value := retryAfter.Load().(string); value != "" {
+				w.Header().Set("Retry-After", value)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"group_id"}`))
+	}))
+	defer server.Close()
+
+	reset := func(n int32, after string) {
+		requests.Store(0)
+		failures.Store(n)
+		retryAfter.Store(after)
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	t.Run("No retries by default", func(t *testing.T) {
+		reset(1, "")
+		_, err := newTestClient(server, nil, WithRetryPolicy(RetryPolicy{})).GetSiteContext(context.Background(), "abc123")
+		require.ErrorIs(t, err, ErrServerError)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("GET should be retried", func(t *testing.T) {
+		reset(2, "")
+		_, err := newTestClient(server, nil, WithRetryPolicy(policy)).GetSiteContext(context.Background(), "abc123")
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("GET should give up after MaxAttempts", func(t *testing.T) {
+		reset(5, "")
+		_, err := newTestClient(server, nil, WithRetryPolicy(policy)).GetSiteContext(context.Background(), "abc123")
+		require.ErrorIs(t, err, ErrServerError)
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("POST should not be retried unless opted in", func(t *testing.T) {
+		reset(1, "")
+		_, err := newTestClient(server, nil, WithRetryPolicy(policy)).AddGroupContext(context.Background(), "group")
+		require.ErrorIs(t, err, ErrServerError)
+		assert.Equal(t, int32(1), requests.Load())
+
+		reset(1, "")
+		mutations := policy
+		mutations.RetryMutations = true
+		id, err := newTestClient(server, nil, WithRetryPolicy(mutations)).AddGroupContext(context.Background(), "group")
+		require.NoError(t, err)
+		assert.Equal(t, "group_id", *id)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("Retry-After should be honoured", func(t *testing.T) {
+		reset(1, "1")
+		honour := policy
+		honour.MaxDelay = 2 * time.Second
+
+		start := time.Now()
+		_, err := newTestClient(server, nil, WithRetryPolicy(honour)).GetSiteContext(context.Background(), "abc123")
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("Retry-After beyond MaxDelay should not be retried", func(t *testing.T) {
+		reset(1, "60")
+		_, err := newTestClient(server, nil, WithRetryPolicy(policy)).GetSiteContext(context.Background(), "abc123")
+		require.ErrorIs(t, err, ErrServerError)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("Backoff should stop when the context is cancelled", func(t *testing.T) {
+		reset(5, "")
+		slow := policy
+		slow.BaseDelay = time.Minute
+		slow.MaxDelay = time.Minute
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := newTestClient(server, nil, WithRetryPolicy(slow)).GetSiteContext(ctx, "abc123")
+		require.ErrorIs(t, err, ErrServerError)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		delay, ok := parseRetryAfter(tt.value, now)
+		assert.Equal(t, tt.ok, ok, tt.value)
+		assert.Equal(t, tt.expected, delay, tt.value)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// signOutTimeout bounds the revocation made by Close.
+const signOutTimeout = 30 * time.Second
+
+// ErrClientClosed is returned by requests made after Close.
+var ErrClientClosed = errors.New("client closed")
+
+// WithRevokeOnClose sets whether Close revokes the refresh token, ending the session everywhere it is used. Disabled
+// by default, so a session persisted with a TokenStore, or tokens shared with whoever issued them, remain usable
+// after Close. SignOut revokes the session explicitly.
+func WithRevokeOnClose(enabled bool) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.RevokeOnClose = enabled
+	}
+}
+
+// Close stops the background token refresh and clears the credentials held in memory, first revoking the refresh
+// token if WithRevokeOnClose is enabled. Requests made after Close fail with ErrClientClosed. Closing an already
+// closed client does nothing.
+func (a *AltaClient) Close() error {
+	if !a.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	if !a.revokeOnClose {
+		a.AuthClient.StopBackgroundRefresh()
+		a.AuthClient.clearSession()
+		a.AuthClient.waitForRefresh()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), signOutTimeout)
+	defer cancel()
+	return a.AuthClient.SignOut(ctx)
+}
+
+// SignOut revokes the refresh token, which also invalidates the ID and access tokens issued with it, then forgets
+// the session and removes it from the token store. The session is forgotten even if the revocation fails, in
+// which case the error is returned as the tokens may still be valid. A remembered device is kept.
+func (auth *AuthClient) SignOut(ctx context.Context) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.signout")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.signout", start, err)
+	}()
+
+	auth.StopBackgroundRefresh()
+	config, result := auth.clearSession()
+	auth.waitForRefresh()
+	auth.deleteSession(ctx, config)
+
+	if result == nil || result.RefreshToken == nil {
+		return nil
+	}
+
+	_, err = auth.cognito.RevokeToken(ctx, &cognitoidentityprovider.RevokeTokenInput{
+		ClientId:     &auth.clientID,
+		ClientSecret: auth.clientSecret,
+		Token:        result.RefreshToken,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// GlobalSignOut invalidates every refresh token issued to the user, signing out all of their sessions, not just
+// this one. The session is then forgotten as with SignOut.
+func (auth *AuthClient) GlobalSignOut(ctx context.Context) (err error) {
+	start := time.Now()
+	ctx, span := auth.tel().startAuth(ctx, "auth.globalsignout")
+	defer func() {
+		auth.tel().endAuth(ctx, span, "auth.globalsignout", start, err)
+	}()
+
+	accessToken, err := auth.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = auth.cognito.GlobalSignOut(ctx, &cognitoidentityprovider.GlobalSignOutInput{AccessToken: accessToken})
+	if err != nil {
+		return fmt.Errorf("failed to sign out: %w", err)
+	}
+
+	auth.StopBackgroundRefresh()
+	config, _ := auth.clearSession()
+	auth.waitForRefresh()
+	auth.deleteSession(ctx, config)
+	return nil
+}
+
+// clearSession forgets the tokens, password and device held in memory, returning the config and tokens of the
+// session cleared. Refreshes still in flight are prevented from restoring the session until the next SignIn.
+func (auth *AuthClient) clearSession() (*Config, *types.AuthenticationResultType) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	auth.signedOut = true
+	config, result := auth.userConfig, auth.auth
+	auth.userConfig = nil
+	auth.auth = nil
+	auth.expiry = time.Time{}
+	auth.device = nil
+	return config, result
+}
+
+// waitForRefresh waits for a refresh in flight to finish, so it can't save the session to the token store after
+// it has been deleted.
+func (auth *AuthClient) waitForRefresh() {
+	auth.refreshing.Lock()
+	defer auth.refreshing.Unlock()
+}
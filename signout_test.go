@@ -0,0 +1,252 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// holdingTransport holds back the response to the next InitiateAuth once hold is set, until release is closed.
+type holdingTransport struct {
+	hold    atomic.Bool
+	held    chan struct{} // Closed once a response is being held
+	release chan struct{}
+}
+
+func newHoldingTransport() *holdingTransport {
+	return &holdingTransport{held: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (t *holdingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if strings.HasSuffix(req.Header.Get("X-Amz-Target"), ".InitiateAuth") && t.hold.CompareAndSwap(true, false) {
+		close(t.held)
+		<-t.release
+	}
+	return resp, err
+}
+
+func TestSignOut(t *testing.T) {
+	const (
+		username = "user@example.com"
+		password = "correct horse"
+	)
+	ctx := context.Background()
+
+	newServer := func(t *testing.T) *cognitoServer {
+		cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+		cognito.AddUser(username, password)
+		return cognito
+	}
+	signIn := func(t *testing.T, cognito *cognitoServer, opts ...newAuthClientOptions) *AuthClient {
+		auth, err := NewAuthClient("", append(cognito.authOptions(), opts...)...)
+		require.NoError(t, err)
+		require.NoError(t, auth.SignInContext(ctx, NewConfig().WithSRPAuth(username, password)))
+		return auth
+	}
+
+	t.Run("SignOut should revoke the refresh token and its tokens", func(t *testing.T) {
+		cognito := newServer(t)
+		auth := signIn(t, cognito)
+		idToken := auth.GetIDToken()
+		require.True(t, cognito.ValidIDToken(idToken))
+
+		require.NoError(t, auth.SignOut(ctx))
+		assert.False(t, cognito.ValidIDToken(idToken))
+		assert.Empty(t, auth.GetIDToken())
+		assert.True(t, auth.GetExpiryTime().IsZero())
+
+		require.ErrorIs(t, auth.RefreshAuthContext(ctx), ErrReauthRequired, "the password should be forgotten")
+		assert.Equal(t, 0, cognito.Refreshes())
+	})
+
+	t.Run("SignOut should remove the stored session", func(t *testing.T) {
+		cognito := newServer(t)
+		store := NewMemoryTokenStore()
+		auth := signIn(t, cognito, WithAuthTokenStore(store))
+
+		key := SessionKey{UserPoolID: "eu-west-2_TestPool", Username: username}
+		_, err := store.Load(ctx, key)
+		require.NoError(t, err)
+
+		require.NoError(t, auth.SignOut(ctx))
+		_, err = store.Load(ctx, key)
+		require.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("SignOut should discard the tokens of a refresh in flight", func(t *testing.T) {
+		cognito := newServer(t)
+		store := NewMemoryTokenStore()
+		transport := newHoldingTransport()
+		auth := signIn(t, cognito, WithAuthTokenStore(store), WithAuthHTTPClient(&http.Client{Transport: transport}))
+
+		// The refresh is granted by Cognito, but its response only arrives once SignOut has started
+		transport.hold.Store(true)
+		refreshed := make(chan error, 1)
+		go func() {
+			refreshed <- auth.RefreshAuthContext(ctx)
+		}()
+		<-transport.held
+
+		signedOut := make(chan error, 1)
+		go func() {
+			signedOut <- auth.SignOut(ctx)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		close(transport.release)
+
+		require.NoError(t, <-signedOut)
+		<-refreshed
+		assert.Empty(t, auth.GetIDToken())
+
+		_, err := store.Load(ctx, SessionKey{UserPoolID: "eu-west-2_TestPool", Username: username})
+		require.ErrorIs(t, err, ErrSessionNotFound)
+
+		require.NoError(t, auth.SignInContext(ctx, NewConfig().WithSRPAuth(username, password)))
+		assert.NotEmpty(t, auth.GetIDToken(), "signing in again should be possible")
+	})
+
+	t.Run("SignOut should send the client secret", func(t *testing.T) {
+		cognito := newServer(t)
+		cognito.clientSecret = "client-secret"
+		auth := signIn(t, cognito)
+		idToken := auth.GetIDToken()
+
+		require.NoError(t, auth.SignOut(ctx))
+		assert.False(t, cognito.ValidIDToken(idToken))
+	})
+
+	t.Run("SignOut without a session should do nothing", func(t *testing.T) {
+		auth, err := NewAuthClient("", newServer(t).authOptions()...)
+		require.NoError(t, err)
+		require.NoError(t, auth.SignOut(ctx))
+	})
+
+	t.Run("GlobalSignOut should end every session of the user", func(t *testing.T) {
+		cognito := newServer(t)
+		auth := signIn(t, cognito)
+		other := signIn(t, cognito)
+		other.WipePassword()
+		otherToken := other.GetIDToken()
+
+		require.NoError(t, auth.GlobalSignOut(ctx))
+		assert.Empty(t, auth.GetIDToken())
+		assert.False(t, cognito.ValidIDToken(otherToken))
+
+		err := other.RefreshAuthContext(ctx)
+		require.ErrorIs(t, err, ErrReauthRequired)
+		var notAuthorized *types.NotAuthorizedException
+		require.ErrorAs(t, err, &notAuthorized)
+	})
+
+	t.Run("GlobalSignOut should require a session", func(t *testing.T) {
+		auth, err := NewAuthClient("", newServer(t).authOptions()...)
+		require.NoError(t, err)
+		require.ErrorIs(t, auth.GlobalSignOut(ctx), ErrNotSignedIn)
+	})
+}
+
+func TestAltaClientClose(t *testing.T) {
+	const (
+		username = "user@example.com"
+		password = "correct horse"
+	)
+
+	cognito := newCognitoServer(t, "eu-west-2_TestPool", "test-client")
+	cognito.AddUser(username, password)
+
+	var requests int
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer api.Close()
+
+	newClient := func(t *testing.T, opts ...newAltaClientOptions) *AltaClient {
+		opts = append([]newAltaClientOptions{WithAltaEndpoint(api.URL + "/"), WithAuthOptions(cognito.authOptions()...)}, opts...)
+		client, err := NewAltaClientContext(context.Background(), username, password, opts...)
+		require.NoError(t, err)
+		return client
+	}
+
+	t.Run("Close should revoke the session and refuse requests", func(t *testing.T) {
+		requests = 0
+		client := newClient(t, WithRevokeOnClose(true))
+		idToken := client.AuthClient.GetIDToken()
+
+		require.NoError(t, client.Close())
+		assert.False(t, cognito.ValidIDToken(idToken))
+		assert.Empty(t, client.AuthClient.GetIDToken())
+
+		_, err := client.ListSitesContext(context.Background())
+		require.ErrorIs(t, err, ErrClientClosed)
+		require.ErrorIs(t, client.MqttConnContext(context.Background()), ErrClientClosed)
+		assert.Equal(t, 0, requests)
+
+		require.NoError(t, client.Close(), "closing twice should do nothing")
+	})
+
+	t.Run("Close should keep the tokens valid by default", func(t *testing.T) {
+		client := newClient(t)
+		idToken := client.AuthClient.GetIDToken()
+
+		require.NoError(t, client.Close())
+		assert.True(t, cognito.ValidIDToken(idToken))
+		assert.Empty(t, client.AuthClient.GetIDToken(), "the tokens should be cleared from memory")
+
+		_, err := client.ListSitesContext(context.Background())
+		require.ErrorIs(t, err, ErrClientClosed)
+	})
+
+	t.Run("Close should keep the session in the token store", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		client := newClient(t, WithTokenStore(store))
+		require.NoError(t, client.Close())
+
+		signIns := cognito.SignIns()
+		client = newClient(t, WithTokenStore(store))
+		defer client.Close()
+		assert.Equal(t, signIns, cognito.SignIns(), "the stored session should be resumed")
+	})
+
+	t.Run("Close should not revoke tokens the client was given", func(t *testing.T) {
+		auth, err := NewAuthClient("", cognito.authOptions()...)
+		require.NoError(t, err)
+		require.NoError(t, auth.SignInContext(context.Background(), NewConfig().WithSRPAuth(username, password)))
+		auth.WipePassword()
+
+		auth.mu.RLock()
+		refreshToken := *auth.auth.RefreshToken
+		auth.mu.RUnlock()
+
+		client, err := NewAltaClientFromToken(context.Background(), auth.GetIDToken(), refreshToken,
+			WithAltaEndpoint(api.URL+"/"), WithAuthOptions(cognito.authOptions()...))
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+
+		require.NoError(t, auth.RefreshAuthContext(context.Background()), "the issuer's refresh token should still work")
+	})
+}
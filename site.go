@@ -16,7 +16,9 @@ limitations under the License.
 package altalabs
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 )
 
 type Site struct {
@@ -58,6 +60,11 @@ type Site struct {
 	AllowNewUsers     bool     `json:"allowNewUsers"`
 }
 
+// LogValue implements slog.LogValuer, redacting secrets.
+func (s Site) LogValue() slog.Value {
+	return redactedLogValue(s)
+}
+
 type newSiteRequest struct {
 	Icon string `json:"icon"`
 	Name string `json:"name"`
@@ -83,7 +90,7 @@ func WithSiteTz(tz string) CreateSiteOption {
 	}
 }
 
-func (a *AltaClient) CreateSite(name string, opts ...CreateSiteOption) (newSiteResponse, error) {
+func (a *AltaClient) CreateSiteContext(ctx context.Context, name string, opts ...CreateSiteOption) (newSiteResponse, error) {
 	newSite := newSiteRequest{
 		Name: name,
 	}
@@ -93,36 +100,46 @@ func (a *AltaClient) CreateSite(name string, opts ...CreateSiteOption) (newSiteR
 	}
 
 	var resp newSiteResponse
-	err := a.postRequest("sites/new", newSite, &resp)
+	err := a.postRequest(ctx, "sites.create", "sites/new", newSite, &resp)
 	if err != nil {
 		return newSiteResponse{}, err
 	}
 	return resp, nil
 }
 
+// CreateSite calls CreateSiteContext with context.Background().
+func (a *AltaClient) CreateSite(name string, opts ...CreateSiteOption) (newSiteResponse, error) {
+	return a.CreateSiteContext(context.Background(), name, opts...)
+}
+
 type GetSiteRequest struct {
-	Id string
+	Id string `url:"id"`
 }
 
-func (a *AltaClient) GetSite(siteID string) (*Site, error) {
+func (a *AltaClient) GetSiteContext(ctx context.Context, siteID string) (*Site, error) {
 	reqParams := GetSiteRequest{
 		Id: siteID,
 	}
 	var site Site
-	err := a.getRequest("site", reqParams, &site)
+	err := a.getRequest(ctx, "sites.get", "site", reqParams, &site)
 	if err != nil {
 		return nil, err
 	}
 	return &site, nil
 }
 
+// GetSite calls GetSiteContext with context.Background().
+func (a *AltaClient) GetSite(siteID string) (*Site, error) {
+	return a.GetSiteContext(context.Background(), siteID)
+}
+
 type renameSiteRequest struct {
 	SiteID string `json:"siteid"`
 	Name   string `json:"name"`
 }
 
-func (a *AltaClient) RenameSite(old, new string) error {
-	sites, err := a.ListSites()
+func (a *AltaClient) RenameSiteContext(ctx context.Context, old, new string) error {
+	sites, err := a.ListSitesContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -140,25 +157,40 @@ func (a *AltaClient) RenameSite(old, new string) error {
 		return errors.New("site not found")
 	}
 
-	return a.RenameSiteByID(siteID, new)
+	return a.RenameSiteByIDContext(ctx, siteID, new)
 }
 
-func (a *AltaClient) RenameSiteByID(siteID, name string) error {
+// RenameSite calls RenameSiteContext with context.Background().
+func (a *AltaClient) RenameSite(old, new string) error {
+	return a.RenameSiteContext(context.Background(), old, new)
+}
+
+func (a *AltaClient) RenameSiteByIDContext(ctx context.Context, siteID, name string) error {
 	req := renameSiteRequest{
 		SiteID: siteID,
 		Name:   name,
 	}
 
-	if err := a.postRequest("sites/rename", req, nil); err != nil {
+	if err := a.postRequest(ctx, "sites.rename", "sites/rename", req, nil); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (a *AltaClient) UpdateSite(site Site) error {
-	if err := a.postRequest("sites/update", site, nil); err != nil {
+// RenameSiteByID calls RenameSiteByIDContext with context.Background().
+func (a *AltaClient) RenameSiteByID(siteID, name string) error {
+	return a.RenameSiteByIDContext(context.Background(), siteID, name)
+}
+
+func (a *AltaClient) UpdateSiteContext(ctx context.Context, site Site) error {
+	if err := a.postRequest(ctx, "sites.update", "sites/update", site, nil); err != nil {
 		return err
 	}
 	return nil
 }
+
+// UpdateSite calls UpdateSiteContext with context.Background().
+func (a *AltaClient) UpdateSite(site Site) error {
+	return a.UpdateSiteContext(context.Background(), site)
+}
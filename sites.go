@@ -15,6 +15,8 @@ limitations under the License.
 
 package altalabs
 
+import "context"
+
 type Sites []site
 
 type site struct {
@@ -37,14 +39,19 @@ type sitePerm struct {
 	UnlockedPasswords bool `json:"unlockedPasswords"`
 }
 
-func (a *AltaClient) ListSites() (Sites, error) {
+func (a *AltaClient) ListSitesContext(ctx context.Context) (Sites, error) {
 	siteURL := "sites/list"
 
 	var sites = make(Sites, 0)
 
-	if err := a.getRequest(siteURL, nil, &sites); err != nil {
+	if err := a.getRequest(ctx, "sites.list", siteURL, nil, &sites); err != nil {
 		return nil, err
 	}
 
 	return sites, nil
 }
+
+// ListSites calls ListSitesContext with context.Background().
+func (a *AltaClient) ListSites() (Sites, error) {
+	return a.ListSitesContext(context.Background())
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// srpNHex is the 3072 bit group Cognito uses for SRP, from amazon-cognito-identity-js.
+const srpNHex = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1" +
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DD" +
+	"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245" +
+	"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED" +
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D" +
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F" +
+	"83655D23DCA3AD961C62F356208552BB9ED529077096966D" +
+	"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B" +
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9" +
+	"DE2BCBF6955817183995497CEA956AE515D2261898FA0510" +
+	"15728E5A8AAAC42DAD33170D04507A33A85521ABDF1CBA64" +
+	"ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7" +
+	"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6B" +
+	"F12FFA06D98A0864D87602733EC86A64521F2B18177B200C" +
+	"BBE117577A615D6C770988C0BAD946E208E24FA074E5AB31" +
+	"43DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF"
+
+// srpTimestampFormat is the format of the TIMESTAMP signed in password claims.
+const srpTimestampFormat = "Mon Jan 2 15:04:05 MST 2006"
+
+var (
+	srpN = hexToInt(srpNHex)
+	srpG = big.NewInt(2)
+	srpK = hexToInt(hashHex("00" + srpNHex + "02"))
+)
+
+// srpClient is the client side of an SRP-6a exchange, as Cognito implements it. The password signed in with
+// cognito-srp covers users, srpClient covers devices, which sign in with the device group key as the pool name and
+// the device key as the username.
+type srpClient struct {
+	a *big.Int
+	A *big.Int
+}
+
+func newSRPClient() (*srpClient, error) {
+	for {
+		a, err := randomInt(128)
+		if err != nil {
+			return nil, err
+		}
+		a.Mod(a, srpN)
+
+		A := new(big.Int).Exp(srpG, a, srpN)
+		if A.Sign() != 0 {
+			return &srpClient{a: a, A: A}, nil
+		}
+	}
+}
+
+// passwordClaim answers a PASSWORD_VERIFIER style challenge, returning the timestamp and signature proving
+// knowledge of the password.
+func (c *srpClient) passwordClaim(poolName, username, password string, params map[string]string, now time.Time) (timestamp, signature string, err error) {
+	salt, ok := new(big.Int).SetString(params["SALT"], 16)
+	if !ok {
+		return "", "", errors.New("invalid challenge parameter SALT")
+	}
+	B, ok := new(big.Int).SetString(params["SRP_B"], 16)
+	if !ok || new(big.Int).Mod(B, srpN).Sign() == 0 {
+		return "", "", errors.New("invalid challenge parameter SRP_B")
+	}
+	secretBlock, err := base64.StdEncoding.DecodeString(params["SECRET_BLOCK"])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid challenge parameter SECRET_BLOCK: %w", err)
+	}
+
+	// S = (B - k * g^x)^(a + u * x) mod N
+	u := srpU(c.A, B)
+	x := srpPasswordExponent(poolName, username, password, salt)
+	base := new(big.Int).Sub(B, new(big.Int).Mul(srpK, new(big.Int).Exp(srpG, x, srpN)))
+	base.Mod(base, srpN)
+	S := new(big.Int).Exp(base, new(big.Int).Add(c.a, new(big.Int).Mul(u, x)), srpN)
+
+	timestamp = now.UTC().Format(srpTimestampFormat)
+	return timestamp, srpSignature(srpKey(S, u), poolName, username, secretBlock, timestamp), nil
+}
+
+// srpVerifier generates a random salt and the verifier g^x for a password, as registered by ConfirmDevice.
+func srpVerifier(poolName, username, password string) (salt, verifier *big.Int, err error) {
+	salt, err = randomInt(16)
+	if err != nil {
+		return nil, nil, err
+	}
+	x := srpPasswordExponent(poolName, username, password, salt)
+	return salt, new(big.Int).Exp(srpG, x, srpN), nil
+}
+
+// srpPasswordExponent returns x = H(salt | H(poolName | username | ":" | password)).
+func srpPasswordExponent(poolName, username, password string, salt *big.Int) *big.Int {
+	userPass := sha256.Sum256([]byte(poolName + username + ":" + password))
+	return hexToInt(hashHex(padHex(salt.Text(16)) + hex.EncodeToString(userPass[:])))
+}
+
+// srpU returns the scrambling parameter u = H(A | B).
+func srpU(A, B *big.Int) *big.Int {
+	return hexToInt(hashHex(padHex(A.Text(16)) + padHex(B.Text(16))))
+}
+
+// srpKey derives the 16 byte HKDF key used to sign the password claim from the shared secret.
+func srpKey(S, u *big.Int) []byte {
+	ikm, _ := hex.DecodeString(padHex(S.Text(16)))
+	salt, _ := hex.DecodeString(padHex(u.Text(16)))
+
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(append([]byte("Caldera Derived Key"), 1))
+	return expand.Sum(nil)[:16]
+}
+
+// srpSignature signs the claim message, poolName | username | secret block | timestamp.
+func srpSignature(key []byte, poolName, username string, secretBlock []byte, timestamp string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(poolName + username))
+	mac.Write(secretBlock)
+	mac.Write([]byte(timestamp))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// srpBytes returns the padded big-endian encoding of n, the form Cognito expects salts and verifiers in.
+func srpBytes(n *big.Int) []byte {
+	b, _ := hex.DecodeString(padHex(n.Text(16)))
+	return b
+}
+
+func hashHex(hexStr string) string {
+	buf, _ := hex.DecodeString(hexStr)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func hexToInt(hexStr string) *big.Int {
+	i, ok := new(big.Int).SetString(hexStr, 16)
+	if !ok {
+		panic(fmt.Sprintf("invalid hex %q", hexStr))
+	}
+	return i
+}
+
+// padHex pads a hex string to whole bytes, adding a leading zero byte if the high bit is set so it reads as
+// positive.
+func padHex(hexStr string) string {
+	if len(hexStr)%2 == 1 {
+		return "0" + hexStr
+	}
+	if strings.ContainsRune("89abcdefABCDEF", rune(hexStr[0])) {
+		return "00" + hexStr
+	}
+	return hexStr
+}
+
+func randomInt(n int) (*big.Int, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate random number: %w", err)
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
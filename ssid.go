@@ -15,7 +15,11 @@ limitations under the License.
 
 package altalabs
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
 
 type SSIDList struct {
 	SSIDs []SSID `json:"ssids"`
@@ -70,11 +74,16 @@ type SSID struct {
 	NotifiedTemplate interface{} `json:"notifiedTemplate"` // TODO: Figure out what this is
 }
 
-func (a *AltaClient) ListSSID() (SSIDList, error) {
+// LogValue implements slog.LogValuer, redacting secrets.
+func (s SSID) LogValue() slog.Value {
+	return redactedLogValue(s)
+}
+
+func (a *AltaClient) ListSSIDContext(ctx context.Context) (SSIDList, error) {
 	URL := "wifi/ssid/list"
 
 	var ssidList SSIDList
-	err := a.getRequest(URL, nil, &ssidList)
+	err := a.getRequest(ctx, "wifi.ssid.list", URL, nil, &ssidList)
 
 	if err != nil {
 		return SSIDList{}, err
@@ -82,6 +91,11 @@ func (a *AltaClient) ListSSID() (SSIDList, error) {
 	return ssidList, nil
 }
 
+// ListSSID calls ListSSIDContext with context.Background().
+func (a *AltaClient) ListSSID() (SSIDList, error) {
+	return a.ListSSIDContext(context.Background())
+}
+
 type NewSSIDRequest struct {
 	Config struct {
 		ID        string `json:"id"`
@@ -127,12 +141,17 @@ type NewSSIDRequest struct {
 	} `json:"config"`
 }
 
+// LogValue implements slog.LogValuer, redacting secrets.
+func (n NewSSIDRequest) LogValue() slog.Value {
+	return redactedLogValue(n)
+}
+
 type NewSSIDResponse struct {
 	ID string `json:"id"`
 }
 
 type GetSSIDRequest struct {
-	ID string
+	ID string `url:"id"`
 }
 
 type GetSSIDResponse struct {
@@ -177,6 +196,11 @@ type GetSSIDResponse struct {
 	NotifiedTemplate any    `json:"notifiedTemplate"`
 }
 
+// LogValue implements slog.LogValuer, redacting secrets.
+func (g GetSSIDResponse) LogValue() slog.Value {
+	return redactedLogValue(g)
+}
+
 type EditSSIDRequest struct {
 	Config struct {
 		ID        string `json:"id,omitempty"`
@@ -216,44 +240,64 @@ type EditSSIDRequest struct {
 	} `json:"config,omitempty"`
 }
 
-func (a *AltaClient) GetSSID(id string) (*GetSSIDResponse, error) {
+// LogValue implements slog.LogValuer, redacting secrets.
+func (e EditSSIDRequest) LogValue() slog.Value {
+	return redactedLogValue(e)
+}
+
+func (a *AltaClient) GetSSIDContext(ctx context.Context, id string) (*GetSSIDResponse, error) {
 	URL := "wifi/ssid"
 	var req = GetSSIDRequest{ID: id}
 
 	var resp GetSSIDResponse
 
-	if err := a.getRequest(URL, req, &resp); err != nil {
+	if err := a.getRequest(ctx, "wifi.ssid.get", URL, req, &resp); err != nil {
 		return nil, fmt.Errorf("failed to get SSID: %w", err)
 	}
 
 	return &resp, nil
 }
 
-func (a *AltaClient) AddSSID(req NewSSIDRequest) (*string, error) {
+// GetSSID calls GetSSIDContext with context.Background().
+func (a *AltaClient) GetSSID(id string) (*GetSSIDResponse, error) {
+	return a.GetSSIDContext(context.Background(), id)
+}
+
+func (a *AltaClient) AddSSIDContext(ctx context.Context, req NewSSIDRequest) (*string, error) {
 	URL := "wifi/ssid"
 
 	var resp NewSSIDResponse
 
-	if err := a.postRequest(URL, req, &resp); err != nil {
+	if err := a.postRequest(ctx, "wifi.ssid.add", URL, req, &resp); err != nil {
 		return nil, fmt.Errorf("failed to add SSID: %w", err)
 	}
 
 	return &resp.ID, nil
 }
 
-func (a *AltaClient) EditSSID(req EditSSIDRequest) error {
+// AddSSID calls AddSSIDContext with context.Background().
+func (a *AltaClient) AddSSID(req NewSSIDRequest) (*string, error) {
+	return a.AddSSIDContext(context.Background(), req)
+}
+
+func (a *AltaClient) EditSSIDContext(ctx context.Context, req EditSSIDRequest) error {
 	URL := "wifi/ssid"
 
-	if err := a.postRequest(URL, req, nil); err != nil {
+	if err := a.postRequest(ctx, "wifi.ssid.edit", URL, req, nil); err != nil {
 		return fmt.Errorf("failed to edit SSID: %w", err)
 	}
 
 	return nil
 }
 
+// EditSSID calls EditSSIDContext with context.Background().
+func (a *AltaClient) EditSSID(req EditSSIDRequest) error {
+	return a.EditSSIDContext(context.Background(), req)
+}
+
 // TODO: Consider xnet ssid creation
 
-func (a *AltaClient) DeleteSSID(id string) error {
+func (a *AltaClient) DeleteSSIDContext(ctx context.Context, id string) error {
 	URL := "wifi/ssid/delete"
 
 	req := struct {
@@ -262,9 +306,14 @@ func (a *AltaClient) DeleteSSID(id string) error {
 		ID: id,
 	}
 
-	if err := a.postRequest(URL, req, nil); err != nil {
+	if err := a.postRequest(ctx, "wifi.ssid.delete", URL, req, nil); err != nil {
 		return fmt.Errorf("failed to delete SSID: %w", err)
 	}
 
 	return nil
 }
+
+// DeleteSSID calls DeleteSSIDContext with context.Background().
+func (a *AltaClient) DeleteSSID(id string) error {
+	return a.DeleteSSIDContext(context.Background(), id)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/mikeee/altalabs-go"
+
+// Attribute keys recorded on spans and metrics.
+const (
+	attrOperation  = attribute.Key("altalabs.operation")
+	attrMethod     = attribute.Key("http.request.method")
+	attrPath       = attribute.Key("url.path")
+	attrStatusCode = attribute.Key("http.response.status_code")
+	attrAttempts   = attribute.Key("altalabs.attempts")
+	attrResult     = attribute.Key("altalabs.result")
+)
+
+// telemetry holds the OpenTelemetry tracer and instruments used by the clients.
+type telemetry struct {
+	tracer trace.Tracer
+
+	requests        metric.Int64Counter     // REST requests sent, by operation and status code
+	requestDuration metric.Float64Histogram // REST request latency in seconds, by operation and status code
+	retries         metric.Int64Counter     // REST requests retried, by operation
+	tokenRefreshes  metric.Int64Counter     // Token refreshes, by result
+	authDuration    metric.Float64Histogram // Cognito sign-in and refresh latency in seconds, by operation
+}
+
+// defaultTelemetry uses the global providers, which are no-ops unless the application has registered its own.
+var defaultTelemetry = sync.OnceValue(func() *telemetry {
+	return newTelemetry(nil, nil)
+})
+
+// newTelemetry creates the tracer and instruments, using the global providers if either is nil.
+func newTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) *telemetry {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+
+	meter := meterProvider.Meter(instrumentationName)
+	t := &telemetry{
+		tracer: tracerProvider.Tracer(instrumentationName),
+	}
+
+	// Instrument creation only fails for invalid names, in which case the meter returns a no-op instrument
+	t.requests, _ = meter.Int64Counter("altalabs.client.requests",
+		metric.WithDescription("Number of API requests sent"),
+		metric.WithUnit("{request}"))
+	t.requestDuration, _ = meter.Float64Histogram("altalabs.client.request.duration",
+		metric.WithDescription("Duration of API requests"),
+		metric.WithUnit("s"))
+	t.retries, _ = meter.Int64Counter("altalabs.client.retries",
+		metric.WithDescription("Number of API requests retried"),
+		metric.WithUnit("{retry}"))
+	t.tokenRefreshes, _ = meter.Int64Counter("altalabs.auth.token.refreshes",
+		metric.WithDescription("Number of auth token refreshes"),
+		metric.WithUnit("{refresh}"))
+	t.authDuration, _ = meter.Float64Histogram("altalabs.auth.duration",
+		metric.WithDescription("Duration of Cognito authentication calls"),
+		metric.WithUnit("s"))
+
+	return t
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider for REST, Cognito and MQTT spans. Defaults to the
+// global provider.
+func WithTracerProvider(provider trace.TracerProvider) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.TracerProvider = provider
+	}
+}
+
+// WithMeterProvider sets the OpenTelemetry meter provider for request, retry and token refresh metrics. Defaults
+// to the global provider.
+func WithMeterProvider(provider metric.MeterProvider) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.MeterProvider = provider
+	}
+}
+
+// WithAuthTracerProvider sets the OpenTelemetry tracer provider for Cognito spans. Defaults to the global provider.
+func WithAuthTracerProvider(provider trace.TracerProvider) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.TracerProvider = provider
+	}
+}
+
+// WithAuthMeterProvider sets the OpenTelemetry meter provider for token refresh metrics. Defaults to the global
+// provider.
+func WithAuthMeterProvider(provider metric.MeterProvider) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.MeterProvider = provider
+	}
+}
+
+func (a *AltaClient) tel() *telemetry {
+	if a.telemetry != nil {
+		return a.telemetry
+	}
+	return defaultTelemetry()
+}
+
+func (auth *AuthClient) tel() *telemetry {
+	if auth.telemetry != nil {
+		return auth.telemetry
+	}
+	return defaultTelemetry()
+}
+
+// startOperation starts the span covering a logical API operation, including retries.
+func (t *telemetry) startOperation(ctx context.Context, req *Request) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, req.Operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrOperation.String(req.Operation),
+			attrMethod.String(req.Method),
+			attrPath.String(req.Path),
+		))
+}
+
+// endOperation records the outcome of an operation on its span and ends it.
+func (t *telemetry) endOperation(span trace.Span, attempts int, err error) {
+	span.SetAttributes(attrAttempts.Int(attempts))
+
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		span.SetAttributes(attrStatusCode.Int(200))
+	case errors.As(err, &apiErr):
+		span.SetAttributes(attrStatusCode.Int(apiErr.StatusCode))
+		fallthrough
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// recordRequest records the metrics for a single request attempt. A status code of 0 means no response was
+// received.
+func (t *telemetry) recordRequest(ctx context.Context, req *Request, statusCode int, duration time.Duration) {
+	attrs := metric.WithAttributes(
+		attrOperation.String(req.Operation),
+		attrMethod.String(req.Method),
+		attrStatusCode.Int(statusCode),
+	)
+	t.requests.Add(ctx, 1, attrs)
+	t.requestDuration.Record(ctx, duration.Seconds(), attrs)
+}
+
+// recordRetry records that an operation is being retried.
+func (t *telemetry) recordRetry(ctx context.Context, req *Request) {
+	t.retries.Add(ctx, 1, metric.WithAttributes(attrOperation.String(req.Operation)))
+}
+
+// startAuth starts a span for a Cognito operation, e.g. auth.signin.
+func (t *telemetry) startAuth(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrOperation.String(operation)))
+}
+
+// endAuth records the outcome and latency of a Cognito operation and ends its span.
+func (t *telemetry) endAuth(ctx context.Context, span trace.Span, operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	t.authDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attrOperation.String(operation),
+		attrResult.String(result)))
+	span.End()
+}
+
+// recordTokenRefresh records the result of a token refresh.
+func (t *telemetry) recordTokenRefresh(ctx context.Context, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	t.tokenRefreshes.Add(ctx, 1, metric.WithAttributes(attrResult.String(result)))
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// collectSums returns the total of each int64 sum metric, keyed by name.
+func collectSums(t *testing.T, reader sdkmetric.Reader) map[string]int64 {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+
+	sums := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					sums[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+	return sums
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTelemetry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/site":
+			if requests.Add(1) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"site"}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	tel := newTelemetry(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+		sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	)
+
+	testIDToken := "id_token"
+	cognito := newFakeCognito(testIDToken)
+	client := &AltaClient{
+		Endpoint:    server.URL + "/",
+		client:      server.Client(),
+		retryPolicy: RetryPolicy{MaxAttempts: 2},
+		telemetry:   tel,
+		AuthClient: &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			userConfig: NewConfig().WithSRPAuth("username", "password"),
+			cognito:    cognito,
+			auth:       &types.AuthenticationResultType{IdToken: &testIDToken},
+			telemetry:  tel,
+		},
+	}
+
+	t.Run("Operations should be traced by logical name", func(t *testing.T) {
+		// The token has expired, so the operation also refreshes it
+		_, err := client.GetSiteContext(context.Background(), "site")
+		require.NoError(t, err)
+
+		err = client.DeleteGroupContext(context.Background(), "group")
+		require.ErrorIs(t, err, ErrForbidden)
+
+		names := map[string]sdktrace.ReadOnlySpan{}
+		for _, span := range spans.Ended() {
+			names[span.Name()] = span
+		}
+		require.Contains(t, names, "sites.get")
+		require.Contains(t, names, "groups.delete")
+		require.Contains(t, names, "auth.refresh")
+		require.Contains(t, names, "auth.signin")
+
+		get := names["sites.get"]
+		assert.Equal(t, int64(2), spanAttribute(get, attrAttempts).AsInt64())
+		assert.Equal(t, int64(http.StatusOK), spanAttribute(get, attrStatusCode).AsInt64())
+		assert.Equal(t, "site", spanAttribute(get, attrPath).AsString())
+		assert.Equal(t, codes.Unset, get.Status().Code)
+
+		del := names["groups.delete"]
+		assert.Equal(t, int64(http.StatusForbidden), spanAttribute(del, attrStatusCode).AsInt64())
+		assert.Equal(t, codes.Error, del.Status().Code)
+
+		// Cognito calls are children of the operation that triggered them
+		assert.Equal(t, get.SpanContext().SpanID(), names["auth.refresh"].Parent().SpanID())
+		assert.Equal(t, names["auth.refresh"].SpanContext().SpanID(), names["auth.signin"].Parent().SpanID())
+	})
+
+	t.Run("Requests, retries and refreshes should be counted", func(t *testing.T) {
+		sums := collectSums(t, reader)
+		assert.Equal(t, int64(3), sums["altalabs.client.requests"])
+		assert.Equal(t, int64(1), sums["altalabs.client.retries"])
+		assert.GreaterOrEqual(t, sums["altalabs.auth.token.refreshes"], int64(1))
+	})
+
+	t.Run("Client options should configure telemetry", func(t *testing.T) {
+		options := loadAltaClientOptions(
+			WithTracerProvider(sdktrace.NewTracerProvider()),
+			WithMeterProvider(sdkmetric.NewMeterProvider()),
+		)
+		assert.NotNil(t, options.TracerProvider)
+		assert.NotNil(t, options.MeterProvider)
+	})
+}
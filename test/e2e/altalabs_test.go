@@ -18,6 +18,7 @@ limitations under the License.
 package e2e
 
 import (
+	"context"
 	"github.com/mikeee/altalabs-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -42,12 +43,12 @@ func Test_AuthClient(t *testing.T) {
 	})
 
 	t.Run("SignIn should be successful", func(t *testing.T) {
-		err = authClient.SignIn(config)
+		err = authClient.SignInContext(context.Background(), config)
 		require.NoError(t, err)
 	})
 
 	t.Run("RefreshAuth should be successful", func(t *testing.T) {
-		err = authClient.RefreshAuth()
+		err = authClient.RefreshAuthContext(context.Background())
 		require.NoError(t, err)
 	})
 
@@ -57,29 +58,29 @@ func Test_AuthClient(t *testing.T) {
 }
 
 func Test_AltaClient(t *testing.T) {
-	client, err := altalabs.NewAltaClient(os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
+	client, err := altalabs.NewAltaClientContext(context.Background(), os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
 
 	t.Run("Client should be valid and be successful with a ListSites request", func(t *testing.T) {
 		require.NoError(t, err)
 		assert.NotNil(t, client)
 
-		sites, err := client.ListSites()
+		sites, err := client.ListSitesContext(context.Background())
 		require.NoError(t, err)
 		assert.NotEmpty(t, sites)
 	})
 
 	t.Run("Refresh token should be successful with a list sites request", func(t *testing.T) {
-		err = client.AuthClient.RefreshAuth()
+		err = client.AuthClient.RefreshAuthContext(context.Background())
 		require.NoError(t, err)
 
-		sites, err := client.ListSites()
+		sites, err := client.ListSitesContext(context.Background())
 		require.NoError(t, err)
 		assert.NotEmpty(t, sites)
 	})
 }
 
 func SetupTestAltaClient() *altalabs.AltaClient {
-	client, err := altalabs.NewAltaClient(os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
+	client, err := altalabs.NewAltaClientContext(context.Background(), os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
 	if err != nil {
 		log.Panicf("Failed to create client: %v", err)
 	}
@@ -18,6 +18,7 @@ limitations under the License.
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"github.com/mikeee/altalabs-go"
 	"github.com/stretchr/testify/require"
@@ -26,9 +27,10 @@ import (
 )
 
 func TestMqttConnection(t *testing.T) {
-	client, err := altalabs.NewAltaClient(os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
+	client, err := altalabs.NewAltaClientContext(context.Background(), os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"),
+		altalabs.WithDefaultSite(os.Getenv("SDK_ALTA_SITE")))
 	require.NoError(t, err)
-	if err := client.MqttConn(); err != nil {
+	if err := client.MqttConnContext(context.Background()); err != nil {
 		fmt.Println(err)
 	}
 
@@ -18,6 +18,7 @@ limitations under the License.
 package e2e
 
 import (
+	"context"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -26,17 +27,17 @@ func Test_AltaClient_Site(t *testing.T) {
 	client := SetupTestAltaClient()
 
 	t.Run("ListSites should return sites", func(t *testing.T) {
-		sites, err := client.ListSites()
+		sites, err := client.ListSitesContext(context.Background())
 		require.NoError(t, err)
 		require.NotEmpty(t, sites)
 	})
 
 	t.Run("Rename", func(t *testing.T) {
 		t.Run("Rename by name", func(t *testing.T) {
-			err := client.RenameSite("RenameSiteTest", "RenameSite2Test")
+			err := client.RenameSiteContext(context.Background(), "RenameSiteTest", "RenameSite2Test")
 			require.NoError(t, err)
 
-			sites, err := client.ListSites()
+			sites, err := client.ListSitesContext(context.Background())
 			require.NoError(t, err)
 			found1 := false
 			for _, site := range sites {
@@ -46,10 +47,10 @@ func Test_AltaClient_Site(t *testing.T) {
 			}
 			require.True(t, found1)
 
-			err = client.RenameSite("RenameSite2Test", "RenameSiteTest")
+			err = client.RenameSiteContext(context.Background(), "RenameSite2Test", "RenameSiteTest")
 			require.NoError(t, err)
 
-			sites, err = client.ListSites()
+			sites, err = client.ListSitesContext(context.Background())
 			require.NoError(t, err)
 			found2 := false
 			for _, site := range sites {
@@ -68,7 +69,7 @@ func Test_AltaClient_Site(t *testing.T) {
 	})
 
 	t.Run("GetSite should return site", func(t *testing.T) {
-		sites, err := client.ListSites()
+		sites, err := client.ListSitesContext(context.Background())
 		require.NoError(t, err)
 		require.NotEmpty(t, sites)
 
@@ -81,7 +82,7 @@ func Test_AltaClient_Site(t *testing.T) {
 		}
 
 		require.NotEmpty(t, siteID)
-		site, err := client.GetSite(siteID)
+		site, err := client.GetSiteContext(context.Background(), siteID)
 		require.NoError(t, err)
 		require.NotEmpty(t, site)
 		require.Equal(t, "getuser", site.Username)
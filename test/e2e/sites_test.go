@@ -18,6 +18,7 @@ limitations under the License.
 package e2e
 
 import (
+	"context"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -26,7 +27,7 @@ func Test_AltaClient_Sites(t *testing.T) {
 	client := SetupTestAltaClient()
 
 	t.Run("ListSites should return sites", func(t *testing.T) {
-		sites, err := client.ListSites()
+		sites, err := client.ListSitesContext(context.Background())
 		require.NoError(t, err)
 		require.NotEmpty(t, sites)
 	})
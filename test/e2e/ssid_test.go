@@ -18,6 +18,7 @@ limitations under the License.
 package e2e
 
 import (
+	"context"
 	"github.com/mikeee/altalabs-go"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -25,12 +26,12 @@ import (
 )
 
 func Test_AltaClient_SSID(t *testing.T) {
-	client, err := altalabs.NewAltaClient(os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
+	client, err := altalabs.NewAltaClientContext(context.Background(), os.Getenv("SDK_ALTA_USER"), os.Getenv("SDK_ALTA_PASS"))
 	if err != nil {
 		panic(err)
 	}
 
-	ssidList, err := client.ListSSID()
+	ssidList, err := client.ListSSIDContext(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,53 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"net/http/httptest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+)
+
+// newTestClient returns a client for server, configured with opts as the constructors would configure it. The client
+// is signed in with auth, or with a token valid for a minute that can't be renewed if auth is nil.
+func newTestClient(server *httptest.Server, auth *AuthClient, opts ...newAltaClientOptions) *AltaClient {
+	if auth == nil {
+		idToken := "id_token"
+		auth = &AuthClient{
+			auth:   &types.AuthenticationResultType{IdToken: &idToken},
+			expiry: time.Now().Add(time.Minute),
+		}
+	}
+
+	options := loadAltaClientOptions(append([]newAltaClientOptions{
+		WithAltaEndpoint(server.URL + "/"),
+		WithHTTPClient(server.Client()),
+	}, opts...)...)
+	return newAltaClient(options, options.httpClient(), auth)
+}
+
+// newSignedInAuthClient returns an AuthClient signed in with idToken until expiry, which signs in again as "username"
+// through cognito.
+func newSignedInAuthClient(cognito cognitoClient, idToken string, expiry time.Time) *AuthClient {
+	return &AuthClient{
+		authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+		userConfig: NewConfig().WithSRPAuth("username", "password"),
+		cognito:    cognito,
+		auth:       &types.AuthenticationResultType{IdToken: &idToken},
+		expiry:     expiry,
+	}
+}
@@ -0,0 +1,496 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// fileStoreKDFIterations is the PBKDF2-SHA256 work factor for encrypted file stores.
+	fileStoreKDFIterations = 600_000
+
+	fileStoreSaltSize = 16
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrStoreEncrypted  = errors.New("token store is encrypted")
+	ErrStoreDecrypt    = errors.New("failed to decrypt token store, wrong passphrase or corrupt file")
+)
+
+// SessionKey identifies a stored session, so several accounts and user pools can share a store.
+type SessionKey struct {
+	UserPoolID string
+	Username   string
+}
+
+func (k SessionKey) String() string {
+	return k.UserPoolID + "/" + k.Username
+}
+
+// Session is a persisted sign in.
+type Session struct {
+	IDToken      string    `json:"idToken"`
+	AccessToken  string    `json:"accessToken,omitempty"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	ExpiresIn    int32     `json:"expiresIn"` // Lifetime of the tokens in seconds when issued
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// TokenStore persists sessions so a process can resume without signing in again. Implementations must be safe for
+// concurrent use.
+type TokenStore interface {
+	// Load returns the stored session, or ErrSessionNotFound if there isn't one.
+	Load(ctx context.Context, key SessionKey) (*Session, error)
+	Save(ctx context.Context, key SessionKey, session *Session) error
+	Delete(ctx context.Context, key SessionKey) error
+}
+
+// WithTokenStore sets the store consulted for a session before signing in, and updated after every sign in and
+// refresh.
+func WithTokenStore(store TokenStore) newAltaClientOptions {
+	return func(options *altaClientOptions) {
+		options.TokenStore = store
+	}
+}
+
+// WithAuthTokenStore sets the store consulted for a session before signing in, and updated after every sign in and
+// refresh.
+func WithAuthTokenStore(store TokenStore) newAuthClientOptions {
+	return func(options *authClientOptions) {
+		options.TokenStore = store
+	}
+}
+
+// newSession converts an authentication result into a Session.
+func newSession(result *types.AuthenticationResultType, expiry time.Time) *Session {
+	return &Session{
+		IDToken:      aws.ToString(result.IdToken),
+		AccessToken:  aws.ToString(result.AccessToken),
+		RefreshToken: aws.ToString(result.RefreshToken),
+		ExpiresIn:    result.ExpiresIn,
+		ExpiresAt:    expiry,
+	}
+}
+
+// authenticationResult converts the session back into an authentication result.
+func (s *Session) authenticationResult() *types.AuthenticationResultType {
+	result := &types.AuthenticationResultType{
+		IdToken:   aws.String(s.IDToken),
+		ExpiresIn: s.ExpiresIn,
+	}
+	if s.AccessToken != "" {
+		result.AccessToken = aws.String(s.AccessToken)
+	}
+	if s.RefreshToken != "" {
+		result.RefreshToken = aws.String(s.RefreshToken)
+	}
+	return result
+}
+
+// MemoryTokenStore keeps sessions and remembered devices in memory, sharing them between clients in the same
+// process.
+type MemoryTokenStore struct {
+	mu       sync.Mutex
+	sessions map[SessionKey]Session
+	devices  map[SessionKey]RememberedDevice
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{sessions: map[SessionKey]Session{}, devices: map[SessionKey]RememberedDevice{}}
+}
+
+func (s *MemoryTokenStore) Load(_ context.Context, key SessionKey) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *MemoryTokenStore) Save(_ context.Context, key SessionKey, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[key] = *session
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(_ context.Context, key SessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, key)
+	return nil
+}
+
+// FileTokenStore keeps sessions in a JSON file readable only by its owner, optionally encrypted with AES-256-GCM
+// using a key derived from a passphrase. Writes replace the file atomically, and are serialised between processes
+// with an advisory lock on a .lock file alongside it where the platform supports one.
+type FileTokenStore struct {
+	path       string
+	passphrase string
+	iterations int
+
+	mu   sync.Mutex
+	salt []byte // Salt of the current key, reused for every write so the key is derived once
+	key  []byte
+}
+
+// fileStoreContents is the plaintext contents of a file store.
+type fileStoreContents struct {
+	Sessions map[string]Session          `json:"sessions"`
+	Devices  map[string]RememberedDevice `json:"devices,omitempty"`
+}
+
+// fileStoreEnvelope wraps the contents of an encrypted file store.
+type fileStoreEnvelope struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewFileTokenStore returns a store keeping sessions in plaintext at path. The file is created with 0600
+// permissions, along with its directory if needed.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+// NewEncryptedFileTokenStore returns a store keeping sessions at path, encrypted with a key derived from the
+// passphrase.
+func NewEncryptedFileTokenStore(path, passphrase string) *FileTokenStore {
+	return &FileTokenStore{path: path, passphrase: passphrase, iterations: fileStoreKDFIterations}
+}
+
+func (s *FileTokenStore) Load(_ context.Context, key SessionKey) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contents, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	session, ok := contents.Sessions[key.String()]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *FileTokenStore) Save(_ context.Context, key SessionKey, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(contents *fileStoreContents) bool {
+		contents.Sessions[key.String()] = *session
+		return true
+	})
+}
+
+func (s *FileTokenStore) Delete(_ context.Context, key SessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func(contents *fileStoreContents) bool {
+		if _, ok := contents.Sessions[key.String()]; !ok {
+			return false
+		}
+		delete(contents.Sessions, key.String())
+		return true
+	})
+}
+
+// update applies a change to the contents of the store, writing them back if change reports they were modified.
+// The read, change and write happen under an advisory lock on a .lock file next to the store, so processes sharing
+// the store don't overwrite each other's changes. The caller must hold mu.
+func (s *FileTokenStore) update(change func(contents *fileStoreContents) bool) error {
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock token store: %w", err)
+	}
+	defer unlock()
+
+	contents, err := s.read()
+	if err != nil {
+		return err
+	}
+	if !change(contents) {
+		return nil
+	}
+	return s.write(contents)
+}
+
+// read returns the contents of the store, empty if the file doesn't exist yet.
+func (s *FileTokenStore) read() (*fileStoreContents, error) {
+	contents := &fileStoreContents{Sessions: map[string]Session{}, Devices: map[string]RememberedDevice{}}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return contents, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token store: %w", err)
+	}
+
+	var envelope fileStoreEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode token store: %w", err)
+	}
+
+	switch {
+	case envelope.Ciphertext != nil && s.passphrase == "":
+		return nil, ErrStoreEncrypted
+	case envelope.Ciphertext == nil && s.passphrase != "":
+		return nil, errors.New("token store is not encrypted")
+	case envelope.Ciphertext != nil:
+		if data, err = s.decrypt(&envelope); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(data, contents); err != nil {
+		return nil, fmt.Errorf("failed to decode token store: %w", err)
+	}
+	if contents.Sessions == nil {
+		contents.Sessions = map[string]Session{}
+	}
+	if contents.Devices == nil {
+		contents.Devices = map[string]RememberedDevice{}
+	}
+	return contents, nil
+}
+
+// write atomically replaces the file with the contents, readable only by its owner.
+func (s *FileTokenStore) write(contents *fileStoreContents) error {
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return fmt.Errorf("failed to encode token store: %w", err)
+	}
+
+	if s.passphrase != "" {
+		envelope, err := s.encrypt(data)
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(envelope); err != nil {
+			return fmt.Errorf("failed to encode token store: %w", err)
+		}
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create token store directory: %w", err)
+	}
+
+	// CreateTemp creates the file with 0600 permissions
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+
+	return nil
+}
+
+// deriveKey returns the encryption key for the salt, reusing the last derived key where possible.
+func (s *FileTokenStore) deriveKey(salt []byte) ([]byte, error) {
+	if s.key != nil && bytes.Equal(s.salt, salt) {
+		return s.key, nil
+	}
+
+	key, err := pbkdf2.Key(sha256.New, s.passphrase, salt, s.iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive token store key: %w", err)
+	}
+
+	s.salt, s.key = salt, key
+	return key, nil
+}
+
+func (s *FileTokenStore) gcm(salt []byte) (cipher.AEAD, error) {
+	key, err := s.deriveKey(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token store cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *FileTokenStore) encrypt(plaintext []byte) (*fileStoreEnvelope, error) {
+	salt := s.salt
+	if salt == nil {
+		salt = make([]byte, fileStoreSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+
+	aead, err := s.gcm(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return &fileStoreEnvelope{
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, salt),
+	}, nil
+}
+
+func (s *FileTokenStore) decrypt(envelope *fileStoreEnvelope) ([]byte, error) {
+	aead, err := s.gcm(envelope.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, ErrStoreDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, envelope.Salt)
+	if err != nil {
+		return nil, ErrStoreDecrypt
+	}
+	return plaintext, nil
+}
+
+// sessionKey returns the key of the user's session in the token store.
+func (auth *AuthClient) sessionKey(config *Config) SessionKey {
+	return SessionKey{UserPoolID: auth.userPoolID, Username: config.Username}
+}
+
+// resumeSession restores the user's stored session, renewing it with its refresh token if it has expired. Other
+// store failures aren't fatal, they are logged and the user signs in again, but a store that can't be decrypted is
+// returned as ErrStoreDecrypt rather than signing in past a wrong passphrase.
+func (auth *AuthClient) resumeSession(ctx context.Context, config *Config) (bool, error) {
+	if auth.tokenStore == nil {
+		return false, nil
+	}
+
+	session, err := auth.tokenStore.Load(ctx, auth.sessionKey(config))
+	if err != nil {
+		if errors.Is(err, ErrStoreDecrypt) {
+			return false, fmt.Errorf("failed to load session: %w", err)
+		}
+		if !errors.Is(err, ErrSessionNotFound) {
+			auth.storeFailed(ctx, fmt.Errorf("failed to load session: %w", err))
+		}
+		return false, nil
+	}
+
+	if auth.checkExpiry(session.ExpiresAt) == nil {
+		auth.setSession(config, session.authenticationResult(), session.ExpiresAt)
+		return true, nil
+	}
+
+	if session.RefreshToken == "" {
+		return false, nil
+	}
+	if err := auth.refreshWithToken(ctx, config, session.IDToken, session.RefreshToken); err != nil {
+		auth.storeFailed(ctx, fmt.Errorf("failed to refresh stored session: %w", err))
+		var notAuthorized *types.NotAuthorizedException
+		if errors.As(err, &notAuthorized) {
+			auth.deleteSession(ctx, config)
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// storeSession saves the current session. Failures are logged rather than failing the sign in.
+func (auth *AuthClient) storeSession(ctx context.Context) {
+	if auth.tokenStore == nil {
+		return
+	}
+
+	auth.mu.RLock()
+	config := auth.userConfig
+	var session *Session
+	if auth.auth != nil && !auth.signedOut {
+		session = newSession(auth.auth, auth.expiry)
+	}
+	auth.mu.RUnlock()
+
+	if config == nil || session == nil {
+		return
+	}
+	if err := auth.tokenStore.Save(ctx, auth.sessionKey(config), session); err != nil {
+		auth.storeFailed(ctx, fmt.Errorf("failed to save session: %w", err))
+	}
+}
+
+// deleteSession removes a session that can no longer be used.
+func (auth *AuthClient) deleteSession(ctx context.Context, config *Config) {
+	if auth.tokenStore == nil || config == nil {
+		return
+	}
+	if err := auth.tokenStore.Delete(ctx, auth.sessionKey(config)); err != nil {
+		auth.storeFailed(ctx, fmt.Errorf("failed to delete session: %w", err))
+	}
+}
+
+// storeFailed reports a token store failure that doesn't fail the operation, recording it on the span and logging
+// it at warn level.
+func (auth *AuthClient) storeFailed(ctx context.Context, err error) {
+	trace.SpanFromContext(ctx).RecordError(err)
+	auth.log().WarnContext(ctx, "Token store failed", slog.String("error", err.Error()))
+}
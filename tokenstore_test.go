@@ -0,0 +1,293 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenStores(t *testing.T) {
+	ctx := context.Background()
+	alice := SessionKey{UserPoolID: "us-east-1_abc123", Username: "alice"}
+	bob := SessionKey{UserPoolID: "us-east-1_abc123", Username: "bob"}
+	otherPool := SessionKey{UserPoolID: "eu-west-1_def456", Username: "alice"}
+	session := &Session{
+		IDToken:      "id_token",
+		AccessToken:  "access_token",
+		RefreshToken: "refresh_token",
+		ExpiresIn:    3600,
+		ExpiresAt:    time.Unix(1700000000, 0).UTC(),
+	}
+
+	newEncryptedStore := func(path, passphrase string) *FileTokenStore {
+		store := NewEncryptedFileTokenStore(path, passphrase)
+		store.iterations = 1000 // Keep the tests fast
+		return store
+	}
+
+	stores := map[string]func(t *testing.T) TokenStore{
+		"memory": func(t *testing.T) TokenStore {
+			return NewMemoryTokenStore()
+		},
+		"file": func(t *testing.T) TokenStore {
+			return NewFileTokenStore(filepath.Join(t.TempDir(), "altalabs", "tokens.json"))
+		},
+		"encrypted file": func(t *testing.T) TokenStore {
+			return newEncryptedStore(filepath.Join(t.TempDir(), "tokens.json"), "passphrase")
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			_, err := store.Load(ctx, alice)
+			require.ErrorIs(t, err, ErrSessionNotFound)
+
+			require.NoError(t, store.Save(ctx, alice, session))
+			require.NoError(t, store.Save(ctx, bob, &Session{IDToken: "bob_token"}))
+
+			loaded, err := store.Load(ctx, alice)
+			require.NoError(t, err)
+			assert.Equal(t, session, loaded)
+
+			loaded, err = store.Load(ctx, bob)
+			require.NoError(t, err)
+			assert.Equal(t, "bob_token", loaded.IDToken)
+
+			_, err = store.Load(ctx, otherPool)
+			require.ErrorIs(t, err, ErrSessionNotFound)
+
+			require.NoError(t, store.Delete(ctx, alice))
+			require.NoError(t, store.Delete(ctx, alice))
+			_, err = store.Load(ctx, alice)
+			require.ErrorIs(t, err, ErrSessionNotFound)
+
+			_, err = store.Load(ctx, bob)
+			require.NoError(t, err)
+		})
+	}
+
+	t.Run("File stores should only be readable by their owner", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "altalabs")
+		path := filepath.Join(dir, "tokens.json")
+		require.NoError(t, NewFileTokenStore(path).Save(ctx, alice, session))
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		info, err = os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.False(t, strings.HasPrefix(entry.Name(), ".tokens.json"), "temporary files should be cleaned up")
+		}
+	})
+
+	t.Run("File stores should be shared between instances", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, newEncryptedStore(path, "passphrase").Save(ctx, alice, session))
+
+		loaded, err := newEncryptedStore(path, "passphrase").Load(ctx, alice)
+		require.NoError(t, err)
+		assert.Equal(t, session, loaded)
+	})
+
+	t.Run("File stores should not lose concurrent changes from other instances", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+
+		// Each instance stands in for a separate process, sharing nothing but the file
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				store := NewFileTokenStore(path)
+				for j := range 5 {
+					key := SessionKey{UserPoolID: "pool", Username: fmt.Sprintf("user-%d-%d", i, j)}
+					assert.NoError(t, store.Save(ctx, key, session))
+				}
+			}()
+		}
+		wg.Wait()
+
+		store := NewFileTokenStore(path)
+		for i := range 8 {
+			for j := range 5 {
+				_, err := store.Load(ctx, SessionKey{UserPoolID: "pool", Username: fmt.Sprintf("user-%d-%d", i, j)})
+				assert.NoError(t, err, "user-%d-%d", i, j)
+			}
+		}
+	})
+
+	t.Run("Encrypted file stores should not leak tokens", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, newEncryptedStore(path, "passphrase").Save(ctx, alice, session))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "refresh_token")
+		assert.NotContains(t, string(data), "alice")
+
+		_, err = newEncryptedStore(path, "wrong").Load(ctx, alice)
+		require.ErrorIs(t, err, ErrStoreDecrypt)
+
+		_, err = NewFileTokenStore(path).Load(ctx, alice)
+		require.ErrorIs(t, err, ErrStoreEncrypted)
+	})
+
+	t.Run("Plaintext file stores should not be opened as encrypted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, NewFileTokenStore(path).Save(ctx, alice, session))
+
+		_, err := newEncryptedStore(path, "passphrase").Load(ctx, alice)
+		require.Error(t, err)
+	})
+}
+
+func TestAuthClientTokenStore(t *testing.T) {
+	ctx := context.Background()
+	config := NewConfig().WithSRPAuth("username", "password")
+
+	newTestAuthClient := func(cognito cognitoClient, store TokenStore) *AuthClient {
+		return &AuthClient{
+			authConfig: &authConfig{userPoolID: "us-east-1_abc123", clientID: "client"},
+			cognito:    cognito,
+			tokenStore: store,
+		}
+	}
+	key := SessionKey{UserPoolID: "us-east-1_abc123", Username: "username"}
+
+	t.Run("Sign ins should be saved and resumed", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		cognito := newFakeCognito("id_token")
+
+		require.NoError(t, newTestAuthClient(cognito, store).SignInContext(ctx, config))
+		assert.Equal(t, 1, cognito.SignIns())
+
+		saved, err := store.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "id_token", saved.IDToken)
+		assert.Equal(t, "refresh_token", saved.RefreshToken)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), saved.ExpiresAt, 2*time.Second)
+
+		resumed := newTestAuthClient(cognito, store)
+		require.NoError(t, resumed.SignInContext(ctx, config))
+		assert.Equal(t, 1, cognito.SignIns(), "a valid stored session should not sign in again")
+		assert.Equal(t, "id_token", resumed.GetIDToken())
+		assert.True(t, saved.ExpiresAt.Equal(resumed.GetExpiryTime()))
+	})
+
+	t.Run("Expired sessions should be refreshed", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		require.NoError(t, store.Save(ctx, key, &Session{
+			IDToken:      "expired_token",
+			RefreshToken: "refresh_token",
+			ExpiresIn:    3600,
+			ExpiresAt:    time.Now().Add(-time.Minute),
+		}))
+		cognito := newFakeCognito("refreshed_token")
+
+		auth := newTestAuthClient(cognito, store)
+		require.NoError(t, auth.SignInContext(ctx, config))
+		assert.Equal(t, 0, cognito.SignIns())
+		assert.Equal(t, 1, cognito.Refreshes())
+		assert.Equal(t, "refreshed_token", auth.GetIDToken())
+
+		saved, err := store.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "refreshed_token", saved.IDToken)
+		assert.Equal(t, "refresh_token", saved.RefreshToken, "the refresh token should be kept")
+	})
+
+	t.Run("Revoked sessions should be replaced by a sign in", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		require.NoError(t, store.Save(ctx, key, &Session{
+			IDToken:      "expired_token",
+			RefreshToken: "revoked_token",
+			ExpiresAt:    time.Now().Add(-time.Minute),
+		}))
+		cognito := newFakeCognito("id_token")
+
+		auth := newTestAuthClient(cognito, store)
+		require.NoError(t, auth.SignInContext(ctx, config))
+		assert.Equal(t, 1, cognito.SignIns())
+
+		saved, err := store.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "refresh_token", saved.RefreshToken)
+	})
+
+	t.Run("Unreadable stores should fall back to signing in with a warning", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+		cognito := newFakeCognito("id_token")
+
+		var buf bytes.Buffer
+		auth := newTestAuthClient(cognito, NewFileTokenStore(path))
+		auth.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+		require.NoError(t, auth.SignInContext(ctx, config))
+		assert.Equal(t, 1, cognito.SignIns())
+
+		assert.Contains(t, buf.String(), `"level":"WARN"`)
+		assert.Contains(t, buf.String(), "failed to load session")
+	})
+
+	t.Run("Stores that can't be decrypted should fail the sign in", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		store := NewEncryptedFileTokenStore(path, "passphrase")
+		store.iterations = 1000
+		require.NoError(t, store.Save(ctx, key, &Session{IDToken: "id_token", ExpiresAt: time.Now().Add(time.Hour)}))
+		cognito := newFakeCognito("id_token")
+
+		wrong := NewEncryptedFileTokenStore(path, "wrong")
+		wrong.iterations = 1000
+		err := newTestAuthClient(cognito, wrong).SignInContext(ctx, config)
+		require.ErrorIs(t, err, ErrStoreDecrypt)
+		assert.Equal(t, 0, cognito.SignIns(), "the store should not be replaced by a new sign in")
+	})
+
+	t.Run("Refreshes should update the store", func(t *testing.T) {
+		store := NewMemoryTokenStore()
+		cognito := newFakeCognito("id_token")
+
+		auth := newTestAuthClient(cognito, store)
+		require.NoError(t, auth.SignInContext(ctx, config))
+
+		cognito.idToken = "refreshed_token"
+		require.NoError(t, auth.RefreshAuthContext(ctx))
+
+		saved, err := store.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "refreshed_token", saved.IDToken)
+	})
+}
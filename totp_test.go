@@ -0,0 +1,111 @@
+/*
+Copyright 2024 Mike Nguyen (mikeee) <hey@mike.ee>
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package altalabs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 seed from RFC 6238 appendix B, "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B SHA1 test vectors, truncated to 6 digits
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTP(rfc6238Secret, time.Unix(tt.unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, code, tt.unix)
+	}
+
+	t.Run("Secrets should be accepted as shown by authenticator setup", func(t *testing.T) {
+		for _, secret := range []string{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ", rfc6238Secret + "===="} {
+			code, err := TOTP(secret, time.Unix(59, 0))
+			require.NoError(t, err)
+			assert.Equal(t, "287082", code, secret)
+		}
+	})
+
+	t.Run("Invalid secrets should error", func(t *testing.T) {
+		for _, secret := range []string{"", "not base32!", "1"} {
+			_, err := TOTP(secret, time.Now())
+			assert.Error(t, err, secret)
+		}
+	})
+}
+
+func TestTOTPChallengeHandler(t *testing.T) {
+	challenge := Challenge{Name: types.ChallengeNameTypeSoftwareTokenMfa}
+
+	t.Run("Codes should match the current period", func(t *testing.T) {
+		handler := NewTOTPChallengeHandler(rfc6238Secret)
+
+		code, err := handler.MFACode(context.Background(), challenge)
+		require.NoError(t, err)
+
+		expected, err := TOTP(rfc6238Secret, time.Now())
+		require.NoError(t, err)
+		previous, err := TOTP(rfc6238Secret, time.Now().Add(-totpPeriod))
+		require.NoError(t, err)
+		assert.Contains(t, []string{expected, previous}, code)
+	})
+
+	t.Run("Codes should not be reused within a period", func(t *testing.T) {
+		handler := NewTOTPChallengeHandler(rfc6238Secret)
+
+		_, err := handler.MFACode(context.Background(), challenge)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		_, err = handler.MFACode(ctx, challenge)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Other challenges should be unhandled", func(t *testing.T) {
+		handler := NewTOTPChallengeHandler(rfc6238Secret)
+
+		_, err := handler.MFACode(context.Background(), Challenge{Name: types.ChallengeNameTypeSmsMfa})
+		require.ErrorIs(t, err, ErrUnhandledChallenge)
+
+		_, _, err = handler.NewPassword(context.Background(), Challenge{Name: types.ChallengeNameTypeNewPasswordRequired})
+		require.ErrorIs(t, err, ErrUnhandledChallenge)
+
+		choice, err := handler.SelectMFAType(context.Background(), []types.ChallengeNameType{types.ChallengeNameTypeSmsMfa, types.ChallengeNameTypeSoftwareTokenMfa})
+		require.NoError(t, err)
+		assert.Equal(t, types.ChallengeNameTypeSoftwareTokenMfa, choice)
+
+		_, err = handler.SelectMFAType(context.Background(), []types.ChallengeNameType{types.ChallengeNameTypeSmsMfa})
+		require.ErrorIs(t, err, ErrUnhandledChallenge)
+	})
+}
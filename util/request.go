@@ -16,27 +16,178 @@ limitations under the License.
 package util
 
 import (
+	"encoding"
 	"fmt"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
-// StructToParams converts a struct to encoded params for appending to URLs as part of paths.
-func StructToParams(data interface{}) string {
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+// EncodeQuery encodes a struct as URL query parameters for appending to paths.
+//
+// Each exported field is named by its `url` tag, falling back to its `json` tag and then its lower-cased field
+// name. A tag of "-" skips the field and the omitempty option skips zero values. Nil pointers are skipped, slices
+// and arrays are encoded as repeated keys and fields of embedded structs are promoted. Values may be strings,
+// booleans, numbers or implement encoding.TextMarshaler.
+func EncodeQuery(data any) (url.Values, error) {
 	values := url.Values{}
+	if data == nil {
+		return values, nil
+	}
+
 	v := reflect.ValueOf(data)
-	t := reflect.TypeOf(data)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return values, nil
+		}
+		v = v.Elem()
+	}
 
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot encode %s as query parameters, expected a struct", v.Type())
+	}
+
+	if err := encodeStruct(values, v); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func encodeStruct(values url.Values, v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
 		fieldType := t.Field(i)
-		tag := fieldType.Tag.Get("json")
-		if tag == "" {
-			tag = strings.ToLower(fieldType.Name)
+		field := v.Field(i)
+
+		name, omitEmpty, named := queryFieldName(fieldType)
+		if name == "-" {
+			continue
+		}
+
+		// Promote the fields of untagged embedded structs
+		if fieldType.Anonymous && !named {
+			embedded := field
+			if embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct && !embedded.Type().Implements(textMarshalerType) {
+				if err := encodeStruct(values, embedded); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if !fieldType.IsExported() {
+			continue
+		}
+
+		if omitEmpty && field.IsZero() {
+			continue
 		}
-		values.Set(tag, fmt.Sprintf("%v", field.Interface()))
+
+		if err := encodeField(values, name, field); err != nil {
+			return fmt.Errorf("failed to encode field %s: %w", fieldType.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// queryFieldName returns the parameter name for a field, whether it has the omitempty option and whether the name
+// was set by a tag.
+func queryFieldName(field reflect.StructField) (name string, omitEmpty bool, named bool) {
+	tag, ok := field.Tag.Lookup("url")
+	if !ok {
+		tag = field.Tag.Get("json")
 	}
 
-	return values.Encode()
+	name, opts, _ := strings.Cut(tag, ",")
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	if name != "" {
+		return name, omitEmpty, true
+	}
+	return strings.ToLower(field.Name), omitEmpty, false
+}
+
+func encodeField(values url.Values, name string, field reflect.Value) error {
+	for field.Kind() == reflect.Pointer || field.Kind() == reflect.Interface {
+		if field.IsNil() {
+			return nil
+		}
+		if field.Type().Implements(textMarshalerType) {
+			break
+		}
+		field = field.Elem()
+	}
+
+	if field.Kind() == reflect.Slice || field.Kind() == reflect.Array {
+		if field.Type().Implements(textMarshalerType) {
+			return encodeValue(values, name, field)
+		}
+		for i := 0; i < field.Len(); i++ {
+			if err := encodeField(values, name, field.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return encodeValue(values, name, field)
+}
+
+func encodeValue(values url.Values, name string, field reflect.Value) error {
+	if marshaler, ok := textMarshaler(field); ok {
+		text, err := marshaler.MarshalText()
+		if err != nil {
+			return err
+		}
+		values.Add(name, string(text))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		values.Add(name, field.String())
+	case reflect.Bool:
+		values.Add(name, strconv.FormatBool(field.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		values.Add(name, strconv.FormatInt(field.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		values.Add(name, strconv.FormatUint(field.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		values.Add(name, strconv.FormatFloat(field.Float(), 'f', -1, field.Type().Bits()))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+// textMarshaler returns the field as an encoding.TextMarshaler, taking its address if the method has a pointer
+// receiver.
+func textMarshaler(field reflect.Value) (encoding.TextMarshaler, bool) {
+	if field.Type().Implements(textMarshalerType) && field.CanInterface() {
+		marshaler, ok := field.Interface().(encoding.TextMarshaler)
+		return marshaler, ok
+	}
+	if field.CanAddr() && field.Addr().Type().Implements(textMarshalerType) && field.Addr().CanInterface() {
+		marshaler, ok := field.Addr().Interface().(encoding.TextMarshaler)
+		return marshaler, ok
+	}
+	return nil, false
 }
@@ -14,3 +14,138 @@ limitations under the License.
 */
 
 package util
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type queryColour int
+
+func (c queryColour) MarshalText() ([]byte, error) {
+	switch c {
+	case 1:
+		return []byte("red"), nil
+	default:
+		return nil, errors.New("unknown colour")
+	}
+}
+
+type queryPage struct {
+	Page  int `url:"page,omitempty"`
+	Limit int `url:"limit,omitempty"`
+}
+
+type queryFilter struct {
+	Name string `url:"name,omitempty"`
+}
+
+func TestEncodeQuery(t *testing.T) {
+	name := "site"
+	empty := ""
+	when := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		data     any
+		expected url.Values
+	}{
+		{"nil", nil, url.Values{}},
+		{"nil pointer", (*queryPage)(nil), url.Values{}},
+		{"untagged field", struct{ Id string }{"abc"}, url.Values{"id": {"abc"}}},
+		{"url tag", struct {
+			ID string `url:"id"`
+		}{"abc"}, url.Values{"id": {"abc"}}},
+		{"url tag takes precedence", struct {
+			SiteName string `json:"site_name" url:"siteName"`
+		}{"home"}, url.Values{"siteName": {"home"}}},
+		{"json tag options are stripped", struct {
+			SiteName string `json:"siteName,omitempty"`
+		}{"home"}, url.Values{"siteName": {"home"}}},
+		{"zero values are kept", struct {
+			Name  string `url:"name"`
+			Count int    `url:"count"`
+		}{}, url.Values{"name": {""}, "count": {"0"}}},
+		{"omitempty", struct {
+			Name  string   `url:"name,omitempty"`
+			Count int      `url:"count,omitempty"`
+			IDs   []string `url:"id,omitempty"`
+		}{}, url.Values{}},
+		{"skipped fields", struct {
+			Name    string `url:"-"`
+			Secret  string `json:"-"`
+			private string
+		}{"a", "b", "c"}, url.Values{}},
+		{"pointers", &struct {
+			Name  *string `url:"name"`
+			Empty *string `url:"empty"`
+			Unset *string `url:"unset"`
+		}{Name: &name, Empty: &empty}, url.Values{"name": {"site"}, "empty": {""}}},
+		{"slices", struct {
+			IDs   []string `url:"id"`
+			Ports [2]int   `url:"port"`
+		}{[]string{"a", "b"}, [2]int{80, 443}}, url.Values{"id": {"a", "b"}, "port": {"80", "443"}}},
+		{"scalars", struct {
+			Enabled bool    `url:"enabled"`
+			Offset  int64   `url:"offset"`
+			Size    uint8   `url:"size"`
+			Ratio   float64 `url:"ratio"`
+		}{true, -1, 8, 0.5}, url.Values{"enabled": {"true"}, "offset": {"-1"}, "size": {"8"}, "ratio": {"0.5"}}},
+		{"embedded structs", struct {
+			queryPage
+			*queryFilter
+			Sort string `url:"sort"`
+		}{queryPage{Page: 2}, &queryFilter{Name: "home"}, "name"},
+			url.Values{"page": {"2"}, "name": {"home"}, "sort": {"name"}}},
+		{"nil embedded struct", struct {
+			*queryFilter
+		}{}, url.Values{}},
+		{"text marshalers", struct {
+			Colour  queryColour   `url:"colour"`
+			Colours []queryColour `url:"colours"`
+			Since   time.Time     `url:"since"`
+			Until   *time.Time    `url:"until,omitempty"`
+		}{1, []queryColour{1, 1}, when, nil},
+			url.Values{"colour": {"red"}, "colours": {"red", "red"}, "since": {"2024-05-01T12:00:00Z"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := EncodeQuery(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+
+	t.Run("special characters should be escaped", func(t *testing.T) {
+		values, err := EncodeQuery(struct {
+			Name string `url:"name"`
+		}{"a&b=c d"})
+		require.NoError(t, err)
+		assert.Equal(t, "name=a%26b%3Dc+d", values.Encode())
+	})
+
+	t.Run("unsupported values should error", func(t *testing.T) {
+		for _, data := range []any{
+			"string",
+			[]string{"a"},
+			struct {
+				Config map[string]string `url:"config"`
+			}{map[string]string{}},
+			struct {
+				Nested queryFilter `url:"nested"`
+			}{},
+			struct {
+				Colour queryColour `url:"colour"`
+			}{2},
+		} {
+			_, err := EncodeQuery(data)
+			assert.Error(t, err, "%#v", data)
+		}
+	})
+}
@@ -16,23 +16,93 @@ limitations under the License.
 package util
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 )
 
-// GenerateTokenPair generates a string suitable to be included in a marshalled JSON object.
-func GenerateTokenPair(token string) (string, error) {
+// TokenField is the body field the API reads the ID token from.
+const TokenField = "token"
+
+var (
+	ErrEmptyToken    = errors.New("empty token")
+	ErrNotJSONObject = errors.New("payload is not a JSON object")
+)
+
+// AddTokenToJSONBody sets the token field on a marshalled JSON object, replacing any existing token.
+func AddTokenToJSONBody(body []byte, token string) ([]byte, error) {
 	if token == "" {
-		return "", errors.New("empty string")
+		return nil, ErrEmptyToken
 	}
 
-	return fmt.Sprintf(`"token":"%s"`, token), nil
+	return SetJSONField(body, TokenField, token)
 }
 
-func AppendTokenToJSONBody(body []byte, token string) ([]byte, error) {
-	if token == "" {
-		return nil, errors.New("empty string")
+// SetJSONField sets a top-level field on a marshalled JSON object, replacing any existing value for the key. The
+// order of the other fields is preserved and the new field is added last. Payloads that aren't a single JSON
+// object are rejected with ErrNotJSONObject.
+func SetJSONField(body []byte, key string, value any) ([]byte, error) {
+	encodedKey, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal value: %w", err)
 	}
 
-	return append(body[:len(body)-1], []byte(fmt.Sprintf(`,%s}`, token))...), nil
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if start, err := decoder.Token(); err != nil || start != json.Delim('{') {
+		return nil, ErrNotJSONObject
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(body) + len(encodedKey) + len(encodedValue) + 2)
+	buf.WriteByte('{')
+
+	for decoder.More() {
+		fieldKey, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON object: %w", err)
+		}
+
+		var fieldValue json.RawMessage
+		if err := decoder.Decode(&fieldValue); err != nil {
+			return nil, fmt.Errorf("invalid JSON object: %w", err)
+		}
+
+		if fieldKey == key {
+			continue
+		}
+
+		encodedFieldKey, err := json.Marshal(fieldKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON object: %w", err)
+		}
+
+		buf.Write(encodedFieldKey)
+		buf.WriteByte(':')
+		buf.Write(fieldValue)
+		buf.WriteByte(',')
+	}
+
+	end, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %w", err)
+	}
+	if end != json.Delim('}') {
+		return nil, fmt.Errorf("invalid JSON object: unexpected %v", end)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, ErrNotJSONObject
+	}
+
+	buf.Write(encodedKey)
+	buf.WriteByte(':')
+	buf.Write(encodedValue)
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
 }
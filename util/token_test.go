@@ -16,29 +16,65 @@ limitations under the License.
 package util
 
 import (
+	"encoding/json"
+	"testing"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"testing"
 )
 
-func TestGenerateTokenPair(t *testing.T) {
-	token := "test"
-	tokenExpected := `"token":"test"`
-	t.Run("GenerateTokenPair should return a token", func(t *testing.T) {
-		tokenPair, err := GenerateTokenPair(token)
-		require.NoError(t, err)
-		assert.Equal(t, tokenExpected, tokenPair)
-	})
-}
+func TestAddTokenToJSONBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		token    string
+		expected string
+		err      error
+	}{
+		{"object", `{"test":"test"}`, "token", `{"test":"test","token":"token"}`, nil},
+		{"empty object", `{}`, "token", `{"token":"token"}`, nil},
+		{"empty object with whitespace", " { \n } ", "token", `{"token":"token"}`, nil},
+		{"nested object", `{"config":{"id":"abc","passwords":[{"network":"lan"}]},"sites":["a"]}`, "token",
+			`{"config":{"id":"abc","passwords":[{"network":"lan"}]},"sites":["a"],"token":"token"}`, nil},
+		{"nested token is kept", `{"config":{"token":"nested"}}`, "token", `{"config":{"token":"nested"},"token":"token"}`, nil},
+		{"existing token is replaced", `{"token":"old","name":"group"}`, "token", `{"name":"group","token":"token"}`, nil},
+		{"special characters", `{"a":1}`, "quote\"back\\slash\nnew</line>ü", `{"a":1,"token":"quote\"back\\slash\nnew\u003c/line\u003eü"}`, nil},
+		{"empty token", `{}`, "", "", ErrEmptyToken},
+		{"array", `[{"a":1}]`, "token", "", ErrNotJSONObject},
+		{"string", `"Unauthorized"`, "token", "", ErrNotJSONObject},
+		{"null", `null`, "token", "", ErrNotJSONObject},
+		{"number", `1`, "token", "", ErrNotJSONObject},
+		{"empty body", ``, "token", "", ErrNotJSONObject},
+		{"trailing data", `{"a":1}{"b":2}`, "token", "", ErrNotJSONObject},
+	}
 
-func TestAppendTokenToJSONBody(t *testing.T) {
-	body := []byte(`{"test":"test"}`)
-	token := `"token":"token"`
-	bodyExpected := []byte(`{"test":"test","token":"token"}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := AddTokenToJSONBody([]byte(tt.body), tt.token)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
 
-	t.Run("AppendTokenToJSONBody should append a token to a JSON body", func(t *testing.T) {
-		bodyWithToken, err := AppendTokenToJSONBody(body, token)
-		require.NoError(t, err)
-		assert.Equal(t, bodyExpected, bodyWithToken)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(body))
+
+			var decoded map[string]any
+			require.NoError(t, json.Unmarshal(body, &decoded), "result must be valid JSON")
+			assert.Equal(t, tt.token, decoded[TokenField])
+		})
+	}
+
+	t.Run("invalid JSON should be rejected", func(t *testing.T) {
+		for _, body := range []string{`{`, `{"a":}`, `{"a":1,}`, `{"a" 1}`} {
+			_, err := AddTokenToJSONBody([]byte(body), "token")
+			assert.Error(t, err, body)
+		}
 	})
 }
+
+func TestSetJSONField(t *testing.T) {
+	body, err := SetJSONField([]byte(`{"id":"abc"}`), "config", map[string]int{"network": 1})
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"abc","config":{"network":1}}`, string(body))
+}